@@ -4,12 +4,11 @@ import (
 	"database/sql"
 	"fmt"
 	"os"
-	"text/tabwriter"
+	"time"
 
 	"github.com/urfave/cli/v2"
 
 	"github.com/appuio/appuio-cloud-reporting/pkg/check"
-	"github.com/appuio/appuio-cloud-reporting/pkg/db"
 )
 
 type checkMissingCommand struct {
@@ -37,13 +36,13 @@ func (cmd *checkMissingCommand) before(context *cli.Context) error {
 
 func (cmd *checkMissingCommand) execute(cliCtx *cli.Context) error {
 	ctx := cliCtx.Context
-	log := AppLogger(ctx).WithName(migrateCommandName)
+	log := AppLogger(ctx).WithName(checkMissingCommandName)
 
-	log.V(1).Info("Opening database connection", "url", cmd.DatabaseURL)
-	rdb, err := db.Openx(cmd.DatabaseURL)
+	rdb, err := openDB(cliCtx, checkMissingCommandName, cmd.DatabaseURL)
 	if err != nil {
-		return fmt.Errorf("could not open database connection: %w", err)
+		return err
 	}
+	defer rdb.Close()
 
 	log.V(1).Info("Begin transaction")
 	tx, err := rdb.BeginTxx(ctx, &sql.TxOptions{ReadOnly: true})
@@ -61,12 +60,76 @@ func (cmd *checkMissingCommand) execute(cliCtx *cli.Context) error {
 		return nil
 	}
 
-	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
-	defer w.Flush()
-	fmt.Fprint(w, "Table\tMissing Field\tID\tSource\n")
-	for _, m := range missing {
-		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", m.Table, m.MissingField, m.ID, m.Source)
-	}
+	printTable(os.Stdout, "Table\tMissing Field\tID\tSource", missing, func(m check.MissingField) []interface{} {
+		return []interface{}{m.Table, m.MissingField, m.ID, m.Source}
+	})
 
 	return cli.Exit(fmt.Sprintf("%d missing entries found.", len(missing)), 1)
 }
+
+type checkGapsCommand struct {
+	DatabaseURL    string
+	From           *time.Time
+	To             *time.Time
+	AllowEmptyRuns bool
+}
+
+var checkGapsCommandName = "check_gaps"
+
+func newCheckGapsCommand() *cli.Command {
+	command := &checkGapsCommand{}
+	return &cli.Command{
+		Name:   checkGapsCommandName,
+		Usage:  "Check for hours without facts or successful report runs in the given period",
+		Before: command.before,
+		Action: command.execute,
+		Flags: []cli.Flag{
+			newDbURLFlag(&command.DatabaseURL),
+			&cli.TimestampFlag{Name: "from", Usage: fmt.Sprintf("Beginning of the period (%s)", time.RFC3339),
+				EnvVars: envVars("FROM"), Layout: time.RFC3339, Required: true, DefaultText: defaultTestForRequiredFlags},
+			&cli.TimestampFlag{Name: "to", Usage: fmt.Sprintf("End of the period, exclusive (%s)", time.RFC3339),
+				EnvVars: envVars("TO"), Layout: time.RFC3339, Required: true, DefaultText: defaultTestForRequiredFlags},
+			&cli.BoolFlag{Name: "allow-empty-runs", Usage: "Don't report hours with a successful report run but without facts as gaps",
+				EnvVars: envVars("ALLOW_EMPTY_RUNS"), Destination: &command.AllowEmptyRuns},
+		},
+	}
+}
+
+func (cmd *checkGapsCommand) before(context *cli.Context) error {
+	cmd.From = context.Timestamp("from")
+	cmd.To = context.Timestamp("to")
+	return LogMetadata(context)
+}
+
+func (cmd *checkGapsCommand) execute(cliCtx *cli.Context) error {
+	ctx := cliCtx.Context
+	log := AppLogger(ctx).WithName(checkGapsCommandName)
+
+	rdb, err := openDB(cliCtx, checkGapsCommandName, cmd.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer rdb.Close()
+
+	log.V(1).Info("Begin transaction")
+	tx, err := rdb.BeginTxx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	gaps, err := check.Gaps(ctx, tx, *cmd.From, *cmd.To, cmd.AllowEmptyRuns)
+	if err != nil {
+		return err
+	}
+
+	if len(gaps) == 0 {
+		return nil
+	}
+
+	printTable(os.Stdout, "Query\tTimestamp\tNo Facts\tNo Run", gaps, func(g check.Gap) []interface{} {
+		return []interface{}{g.Query, g.Timestamp.Format(time.RFC3339), g.NoFacts, g.NoRun}
+	})
+
+	return cli.Exit(fmt.Sprintf("%d gaps found.", len(gaps)), 1)
+}
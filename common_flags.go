@@ -1,7 +1,16 @@
 package main
 
 import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	apiv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+
 	"github.com/appuio/appuio-cloud-reporting/pkg/db"
+	"github.com/appuio/appuio-cloud-reporting/pkg/report"
 	"github.com/urfave/cli/v2"
 )
 
@@ -12,9 +21,99 @@ func newDbURLFlag(destination *string) *cli.StringFlag {
 		EnvVars: envVars("DB_URL"), Destination: destination, Required: true, DefaultText: defaultTestForRequiredFlags}
 }
 
-func newPromURLFlag(destination *string) *cli.StringFlag {
-	return &cli.StringFlag{Name: "prom-url", Usage: "Prometheus connection URL in the form of http://host:port",
-		EnvVars: envVars("PROM_URL"), Destination: destination, Value: "http://localhost:9090"}
+// openDB opens the database connection of the command with the given name.
+func openDB(cliCtx *cli.Context, commandName, url string) (*sqlx.DB, error) {
+	log := AppLogger(cliCtx.Context).WithName(commandName)
+	log.V(1).Info("Opening database connection", "url", url)
+	rdb, err := db.Openx(url)
+	if err != nil {
+		return nil, fmt.Errorf("could not open database connection: %w", err)
+	}
+	return rdb, nil
+}
+
+// printTable prints the rows as a table with the given tab separated header.
+// The columns of a row are returned by columns and formatted with their default format.
+func printTable[T any](out io.Writer, header string, rows []T, columns func(T) []interface{}) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintln(w, header)
+	for _, r := range rows {
+		for i, c := range columns(r) {
+			if i > 0 {
+				fmt.Fprint(w, "\t")
+			}
+			fmt.Fprint(w, c)
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+func newPromWarningsFlag(destination *string) *cli.StringFlag {
+	return &cli.StringFlag{Name: "prom-warnings", Usage: fmt.Sprintf("How to handle warnings returned by prometheus, warnings are always recorded on the report run (values: %v)", report.WarningsPolicies),
+		EnvVars: envVars("PROM_WARNINGS"), Destination: destination, Value: string(report.RecordWarnings)}
+}
+
+// promRetryFlags holds the flags configuring retries of failed prometheus queries.
+type promRetryFlags struct {
+	Attempts   int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+var promErrorTypes = []apiv1.ErrorType{apiv1.ErrBadData, apiv1.ErrTimeout, apiv1.ErrCanceled, apiv1.ErrExec, apiv1.ErrBadResponse, apiv1.ErrServer, apiv1.ErrClient, report.ErrTransport}
+
+func (f *promRetryFlags) flags() []cli.Flag {
+	retryOn := make([]string, len(report.DefaultRetryableErrors))
+	for i, t := range report.DefaultRetryableErrors {
+		retryOn[i] = string(t)
+	}
+	return []cli.Flag{
+		&cli.IntFlag{Name: "prom-query-attempts", Usage: "Maximum number of attempts of a prometheus query, 1 disables retries",
+			EnvVars: envVars("PROM_QUERY_ATTEMPTS"), Destination: &f.Attempts, Value: 1},
+		&cli.DurationFlag{Name: "prom-query-backoff", Usage: "Time to wait before retrying a prometheus query, doubles with every retry",
+			EnvVars: envVars("PROM_QUERY_BACKOFF"), Destination: &f.Backoff, Value: time.Second},
+		&cli.DurationFlag{Name: "prom-query-max-backoff", Usage: "Maximum time to wait between retries of a prometheus query",
+			EnvVars: envVars("PROM_QUERY_MAX_BACKOFF"), Destination: &f.MaxBackoff, Value: time.Minute},
+		&cli.StringSliceFlag{Name: "prom-query-retry-on", Usage: fmt.Sprintf("Prometheus error types to retry, can be repeated (values: %v)", promErrorTypes),
+			EnvVars: envVars("PROM_QUERY_RETRY_ON"), Value: cli.NewStringSlice(retryOn...)},
+	}
+}
+
+func (f *promRetryFlags) policy(cliCtx *cli.Context) (report.RetryPolicy, error) {
+	policy := report.RetryPolicy{
+		MaxAttempts:     f.Attempts,
+		InitialBackoff:  f.Backoff,
+		MaxBackoff:      f.MaxBackoff,
+		RetryableErrors: []apiv1.ErrorType{},
+	}
+	for _, raw := range cliCtx.StringSlice("prom-query-retry-on") {
+		valid := false
+		for _, t := range promErrorTypes {
+			if string(t) == raw {
+				policy.RetryableErrors = append(policy.RetryableErrors, t)
+				valid = true
+			}
+		}
+		if !valid {
+			return policy, fmt.Errorf("unknown prometheus error type %q (values: %v)", raw, promErrorTypes)
+		}
+	}
+	return policy, nil
+}
+
+func newAtFlag(required bool, usage string) *cli.TimestampFlag {
+	defaultText := "not set"
+	if required {
+		defaultText = defaultTestForRequiredFlags
+	}
+	return &cli.TimestampFlag{Name: "at", Usage: fmt.Sprintf("%s (%s)", usage, time.RFC3339),
+		Layout: time.RFC3339, Required: required, DefaultText: defaultText}
+}
+
+func newDuringFlag(destination *string, usage string) *cli.StringFlag {
+	return &cli.StringFlag{Name: "during", Usage: fmt.Sprintf("%s in the form of from,until (%s), an empty bound is unbounded", usage, time.RFC3339),
+		Destination: destination, Value: "-infinity,infinity"}
 }
 
 func queryNames(queries []db.Query) []string {
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/urfave/cli/v2"
+
+	"github.com/appuio/appuio-cloud-reporting/pkg/db"
+	"github.com/appuio/appuio-cloud-reporting/pkg/sourcekey"
+)
+
+type discountsCommand struct {
+	DatabaseURL string
+	ID          string
+	Source      string
+	Discount    float64
+	During      string
+	At          *time.Time
+}
+
+var discountsCommandName = "discounts"
+
+func newDiscountsCommand() *cli.Command {
+	command := &discountsCommand{}
+	return &cli.Command{
+		Name:  discountsCommandName,
+		Usage: "Manage discounts and their validity",
+		Subcommands: []*cli.Command{
+			{
+				Name:   "list",
+				Usage:  "List discounts",
+				Before: command.before,
+				Action: command.list,
+				Flags: []cli.Flag{
+					newDbURLFlag(&command.DatabaseURL),
+					newAtFlag(false, "Only list discounts valid at this timestamp"),
+				},
+			},
+			{
+				Name:   "create",
+				Usage:  "Create a new discount",
+				Before: command.before,
+				Action: command.create,
+				Flags: []cli.Flag{
+					newDbURLFlag(&command.DatabaseURL),
+					command.newSourceFlag(),
+					command.newDiscountFlag(),
+					newDuringFlag(&command.During, "Validity of the discount"),
+				},
+			},
+			{
+				Name:   "supersede",
+				Usage:  "End the validity of the current discount at the given timestamp and create a new discount starting at that timestamp",
+				Before: command.before,
+				Action: command.supersede,
+				Flags: []cli.Flag{
+					newDbURLFlag(&command.DatabaseURL),
+					command.newSourceFlag(),
+					newAtFlag(true, "Timestamp at which the current discount ends and the new one starts"),
+					command.newDiscountFlag(),
+				},
+			},
+			{
+				Name:   "delete",
+				Usage:  "Delete a discount not referenced by any facts",
+				Before: command.before,
+				Action: command.delete,
+				Flags: []cli.Flag{
+					newDbURLFlag(&command.DatabaseURL),
+					&cli.StringFlag{Name: "id", Usage: "ID of the discount",
+						Destination: &command.ID, Required: true, DefaultText: defaultTestForRequiredFlags},
+				},
+			},
+		},
+	}
+}
+
+func (cmd *discountsCommand) newSourceFlag() *cli.StringFlag {
+	return &cli.StringFlag{Name: "source", Usage: "Source of the discount in the form of query:zone:tenant:namespace:class, can be shortened and contain wildcards",
+		Destination: &cmd.Source, Required: true, DefaultText: defaultTestForRequiredFlags}
+}
+
+func (cmd *discountsCommand) newDiscountFlag() *cli.Float64Flag {
+	return &cli.Float64Flag{Name: "discount", Usage: "Discount between 0 and 1. 0.3 discount equals price per unit * 0.7",
+		Destination: &cmd.Discount, Required: true, DefaultText: defaultTestForRequiredFlags}
+}
+
+func (cmd *discountsCommand) before(context *cli.Context) error {
+	cmd.At = context.Timestamp("at")
+	return LogMetadata(context)
+}
+
+// validate checks the given source and discount before writing them to the database.
+// Discounts with a source not matching any source key are never applied.
+func (cmd *discountsCommand) validate() error {
+	if err := sourcekey.ValidateLookupKey(cmd.Source); err != nil {
+		return fmt.Errorf("invalid source: %w", err)
+	}
+	if cmd.Discount < 0 || cmd.Discount > 1 {
+		return fmt.Errorf("invalid discount %g: must be between 0 and 1", cmd.Discount)
+	}
+	return nil
+}
+
+func (cmd *discountsCommand) list(cliCtx *cli.Context) error {
+	rdb, err := openDB(cliCtx, discountsCommandName, cmd.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer rdb.Close()
+
+	discounts, err := db.ListDiscounts(cliCtx.Context, rdb, cmd.At)
+	if err != nil {
+		return err
+	}
+	printDiscounts(os.Stdout, discounts...)
+	return nil
+}
+
+func (cmd *discountsCommand) create(cliCtx *cli.Context) error {
+	if err := cmd.validate(); err != nil {
+		return err
+	}
+	during, err := db.ParseTimerange(cmd.During)
+	if err != nil {
+		return fmt.Errorf("invalid during: %w", err)
+	}
+
+	rdb, err := openDB(cliCtx, discountsCommandName, cmd.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer rdb.Close()
+
+	var created db.Discount
+	err = db.RunInTransaction(cliCtx.Context, rdb, func(tx *sqlx.Tx) error {
+		created, err = db.CreateDiscount(tx, db.Discount{
+			Source:   cmd.Source,
+			Discount: cmd.Discount,
+			During:   during,
+		})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("could not create discount: %w", err)
+	}
+	printDiscounts(os.Stdout, created)
+	return nil
+}
+
+func (cmd *discountsCommand) supersede(cliCtx *cli.Context) error {
+	if err := cmd.validate(); err != nil {
+		return err
+	}
+
+	rdb, err := openDB(cliCtx, discountsCommandName, cmd.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer rdb.Close()
+
+	var superseded, created db.Discount
+	err = db.RunInTransaction(cliCtx.Context, rdb, func(tx *sqlx.Tx) error {
+		superseded, created, err = db.SupersedeDiscount(cliCtx.Context, tx, cmd.Source, *cmd.At, db.Discount{
+			Discount: cmd.Discount,
+		})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("could not supersede discount: %w", err)
+	}
+	printDiscounts(os.Stdout, superseded, created)
+	return nil
+}
+
+func (cmd *discountsCommand) delete(cliCtx *cli.Context) error {
+	rdb, err := openDB(cliCtx, discountsCommandName, cmd.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer rdb.Close()
+
+	if err := db.DeleteDiscount(cliCtx.Context, rdb, cmd.ID); err != nil {
+		return fmt.Errorf("could not delete discount: %w", err)
+	}
+	AppLogger(cliCtx.Context).WithName(discountsCommandName).Info("Deleted discount", "id", cmd.ID)
+	return nil
+}
+
+func printDiscounts(out io.Writer, discounts ...db.Discount) {
+	printTable(out, "ID\tSource\tDiscount\tDuring", discounts, func(d db.Discount) []interface{} {
+		return []interface{}{d.Id, d.Source, d.Discount, db.FormatTimerange(d.During)}
+	})
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/urfave/cli/v2"
+
+	"github.com/appuio/appuio-cloud-reporting/pkg/db"
+)
+
+type exchangeRatesCommand struct {
+	DatabaseURL    string
+	ID             string
+	SourceCurrency string
+	TargetCurrency string
+	Rate           float64
+	During         string
+	At             *time.Time
+}
+
+var exchangeRatesCommandName = "exchange-rates"
+
+func newExchangeRatesCommand() *cli.Command {
+	command := &exchangeRatesCommand{}
+	return &cli.Command{
+		Name:  exchangeRatesCommandName,
+		Usage: "Manage exchange rates used to convert prices to the billing currency of tenants",
+		Subcommands: []*cli.Command{
+			{
+				Name:   "list",
+				Usage:  "List exchange rates",
+				Before: command.before,
+				Action: command.list,
+				Flags: []cli.Flag{
+					newDbURLFlag(&command.DatabaseURL),
+					newAtFlag(false, "Only list exchange rates valid at this timestamp"),
+				},
+			},
+			{
+				Name:   "create",
+				Usage:  "Create a new exchange rate",
+				Before: command.before,
+				Action: command.create,
+				Flags: []cli.Flag{
+					newDbURLFlag(&command.DatabaseURL),
+					&cli.StringFlag{Name: "source-currency", Usage: "ISO 4217 code of the currency of the product prices (example: EUR)",
+						Destination: &command.SourceCurrency, Required: true, DefaultText: defaultTestForRequiredFlags},
+					&cli.StringFlag{Name: "target-currency", Usage: "ISO 4217 code of the billing currency (example: CHF)",
+						Destination: &command.TargetCurrency, Required: true, DefaultText: defaultTestForRequiredFlags},
+					&cli.Float64Flag{Name: "rate", Usage: "Amount in the target currency of one unit of the source currency",
+						Destination: &command.Rate, Required: true, DefaultText: defaultTestForRequiredFlags},
+					newDuringFlag(&command.During, "Validity of the exchange rate"),
+				},
+			},
+			{
+				Name:   "delete",
+				Usage:  "Delete an exchange rate",
+				Before: command.before,
+				Action: command.delete,
+				Flags: []cli.Flag{
+					newDbURLFlag(&command.DatabaseURL),
+					&cli.StringFlag{Name: "id", Usage: "ID of the exchange rate",
+						Destination: &command.ID, Required: true, DefaultText: defaultTestForRequiredFlags},
+				},
+			},
+		},
+	}
+}
+
+func (cmd *exchangeRatesCommand) before(context *cli.Context) error {
+	cmd.At = context.Timestamp("at")
+	return LogMetadata(context)
+}
+
+// validate checks the given currencies and rate before writing them to the database.
+func (cmd *exchangeRatesCommand) validate() error {
+	if err := db.ValidateCurrency(cmd.SourceCurrency); err != nil {
+		return err
+	}
+	if err := db.ValidateCurrency(cmd.TargetCurrency); err != nil {
+		return err
+	}
+	if cmd.SourceCurrency == cmd.TargetCurrency {
+		return fmt.Errorf("source and target currency must differ")
+	}
+	if cmd.Rate <= 0 {
+		return fmt.Errorf("invalid rate %g: must be greater than 0", cmd.Rate)
+	}
+	return nil
+}
+
+func (cmd *exchangeRatesCommand) list(cliCtx *cli.Context) error {
+	rdb, err := openDB(cliCtx, exchangeRatesCommandName, cmd.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer rdb.Close()
+
+	rates, err := db.ListExchangeRates(cliCtx.Context, rdb, cmd.At)
+	if err != nil {
+		return err
+	}
+	printExchangeRates(os.Stdout, rates...)
+	return nil
+}
+
+func (cmd *exchangeRatesCommand) create(cliCtx *cli.Context) error {
+	if err := cmd.validate(); err != nil {
+		return err
+	}
+	during, err := db.ParseTimerange(cmd.During)
+	if err != nil {
+		return fmt.Errorf("invalid during: %w", err)
+	}
+
+	rdb, err := openDB(cliCtx, exchangeRatesCommandName, cmd.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer rdb.Close()
+
+	var created db.ExchangeRate
+	err = db.RunInTransaction(cliCtx.Context, rdb, func(tx *sqlx.Tx) error {
+		created, err = db.CreateExchangeRate(tx, db.ExchangeRate{
+			SourceCurrency: cmd.SourceCurrency,
+			TargetCurrency: cmd.TargetCurrency,
+			Rate:           cmd.Rate,
+			During:         during,
+		})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("could not create exchange rate: %w", err)
+	}
+	printExchangeRates(os.Stdout, created)
+	return nil
+}
+
+func (cmd *exchangeRatesCommand) delete(cliCtx *cli.Context) error {
+	rdb, err := openDB(cliCtx, exchangeRatesCommandName, cmd.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer rdb.Close()
+
+	if err := db.DeleteExchangeRate(cliCtx.Context, rdb, cmd.ID); err != nil {
+		return fmt.Errorf("could not delete exchange rate: %w", err)
+	}
+	AppLogger(cliCtx.Context).WithName(exchangeRatesCommandName).Info("Deleted exchange rate", "id", cmd.ID)
+	return nil
+}
+
+func printExchangeRates(out io.Writer, rates ...db.ExchangeRate) {
+	printTable(out, "ID\tSource\tTarget\tRate\tDuring", rates, func(r db.ExchangeRate) []interface{} {
+		return []interface{}{r.Id, r.SourceCurrency, r.TargetCurrency, r.Rate, db.FormatTimerange(r.During)}
+	})
+}
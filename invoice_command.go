@@ -2,14 +2,13 @@ package main
 
 import (
 	"database/sql"
-	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"time"
 
 	"github.com/urfave/cli/v2"
 
-	"github.com/appuio/appuio-cloud-reporting/pkg/db"
 	"github.com/appuio/appuio-cloud-reporting/pkg/invoice"
 )
 
@@ -17,62 +16,169 @@ type invoiceCommand struct {
 	DatabaseURL string
 	Year        int
 	Month       time.Month
+	Output      string
+
+	Template  string
+	PDF       bool
+	OutputDir string
 }
 
 var invoiceCommandName = "invoice"
 
 func newInvoiceCommand() *cli.Command {
 	command := &invoiceCommand{}
+	// Required flags are checked in execute to allow running the subcommands without them.
+	dbURLFlag := newDbURLFlag(&command.DatabaseURL)
+	dbURLFlag.Required = false
 	return &cli.Command{
 		Name:   invoiceCommandName,
 		Usage:  "Run a invoice for a query in the given period",
 		Before: command.before,
 		Action: command.execute,
-		Flags: []cli.Flag{
-			newDbURLFlag(&command.DatabaseURL),
-
-			&cli.IntFlag{Name: "year", Usage: "Year to generate the report for.",
-				EnvVars: envVars("YEAR"), Destination: &command.Year, Required: true},
-			&cli.IntFlag{Name: "month", Usage: "Month to generate the report for.",
-				EnvVars: envVars("MONTH"), Destination: (*int)(&command.Month), Required: true},
+		Subcommands: []*cli.Command{
+			{
+				Name:   "render",
+				Usage:  "Render the invoices of the given period as one HTML and optionally PDF document per tenant",
+				Action: command.render,
+				Flags: append(command.periodFlags(),
+					newDbURLFlag(&command.DatabaseURL),
+					&cli.StringFlag{Name: "template", Usage: "HTML template rendering an invoice, see pkg/invoice/templates/invoice.html.tmpl (default: built-in template)",
+						EnvVars: envVars("INVOICE_TEMPLATE"), Destination: &command.Template},
+					&cli.BoolFlag{Name: "pdf", Usage: "Convert the rendered HTML to PDF, supports headings, paragraphs, tables and bold and italic text",
+						EnvVars: envVars("INVOICE_PDF"), Destination: &command.PDF},
+					&cli.StringFlag{Name: "output-dir", Usage: "Directory to write the documents to, one subdirectory per year, month and tenant target",
+						EnvVars: envVars("INVOICE_OUTPUT_DIR"), Destination: &command.OutputDir, Value: "invoices"},
+				),
+			},
 		},
+		Flags: append(command.periodFlags(),
+			dbURLFlag,
+			&cli.StringFlag{Name: "output", Aliases: []string{"o"}, Usage: "Output format (values: [json, yaml, csv, table])",
+				EnvVars: envVars("OUTPUT"), Destination: &command.Output, Value: "json"},
+		),
 	}
 }
 
-func (cmd *invoiceCommand) before(context *cli.Context) error {
-	if cmd.Month < 1 || cmd.Month > 12 {
-		return fmt.Errorf("unknown month %q", cmd.Month)
+// periodFlags returns the flags selecting the billed period and tenants.
+// Either year and month or from and to are required, this is checked in period.
+func (cmd *invoiceCommand) periodFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.IntFlag{Name: "year", Usage: "Year to generate the report for.",
+			EnvVars: envVars("YEAR"), Destination: &cmd.Year},
+		&cli.IntFlag{Name: "month", Usage: "Month to generate the report for.",
+			EnvVars: envVars("MONTH"), Destination: (*int)(&cmd.Month)},
+		&cli.TimestampFlag{Name: "from", Usage: fmt.Sprintf("Beginning of the period, instead of --year and --month (%s)", time.RFC3339),
+			EnvVars: envVars("FROM"), Layout: time.RFC3339, DefaultText: "none"},
+		&cli.TimestampFlag{Name: "to", Usage: fmt.Sprintf("End of the period, exclusive (%s)", time.RFC3339),
+			EnvVars: envVars("TO"), Layout: time.RFC3339, DefaultText: "none"},
+		&cli.StringSliceFlag{Name: "tenant", Usage: "Only generate invoices for the tenant with this source or target, can be repeated (default: all tenants)",
+			EnvVars: envVars("TENANT")},
 	}
+}
+
+func (cmd *invoiceCommand) before(context *cli.Context) error {
 	return LogMetadata(context)
 }
 
+// period returns the period selected by either the year and month or the from and to flags.
+func (cmd *invoiceCommand) period(cliCtx *cli.Context) (from time.Time, to time.Time, err error) {
+	monthly := cliCtx.IsSet("year") || cliCtx.IsSet("month")
+	ranged := cliCtx.IsSet("from") || cliCtx.IsSet("to")
+	switch {
+	case monthly && ranged:
+		return from, to, fmt.Errorf("flags \"year\" and \"month\" can't be used together with \"from\" and \"to\"")
+	case ranged:
+		if !cliCtx.IsSet("from") || !cliCtx.IsSet("to") {
+			return from, to, fmt.Errorf("required flags \"from\" and \"to\" not set")
+		}
+		return *cliCtx.Timestamp("from"), *cliCtx.Timestamp("to"), nil
+	default:
+		if !cliCtx.IsSet("year") || !cliCtx.IsSet("month") {
+			return from, to, fmt.Errorf("required flags \"year\" and \"month\" or \"from\" and \"to\" not set")
+		}
+		if cmd.Month < 1 || cmd.Month > 12 {
+			return from, to, fmt.Errorf("unknown month %d", cmd.Month)
+		}
+		from = time.Date(cmd.Year, cmd.Month, 1, 0, 0, 0, 0, time.UTC)
+		return from, from.AddDate(0, 1, 0), nil
+	}
+}
+
 func (cmd *invoiceCommand) execute(cliCtx *cli.Context) error {
-	ctx := cliCtx.Context
-	log := AppLogger(ctx).WithName(invoiceCommandName)
+	if !cliCtx.IsSet("db-url") {
+		return fmt.Errorf("required flag %q not set", "db-url")
+	}
+	from, to, err := cmd.period(cliCtx)
+	if err != nil {
+		return err
+	}
+
+	encode, ok := map[string]func(io.Writer, []invoice.Invoice) error{
+		"json":  invoice.EncodeJSON,
+		"yaml":  invoice.EncodeYAML,
+		"csv":   invoice.EncodeCSV,
+		"table": invoice.EncodeTable,
+	}[cmd.Output]
+	if !ok {
+		return fmt.Errorf("unknown output format %q", cmd.Output)
+	}
 
-	log.V(1).Info("Opening database connection", "url", cmd.DatabaseURL)
-	rdb, err := db.Openx(cmd.DatabaseURL)
+	invoices, err := cmd.generate(cliCtx, from, to)
 	if err != nil {
-		return fmt.Errorf("could not open database connection: %w", err)
+		return err
 	}
-	defer rdb.Close()
+	return encode(os.Stdout, invoices)
+}
 
-	log.V(1).Info("Begin transaction")
-	tx, err := rdb.BeginTxx(ctx, &sql.TxOptions{ReadOnly: true})
+func (cmd *invoiceCommand) render(cliCtx *cli.Context) error {
+	log := AppLogger(cliCtx.Context).WithName(invoiceCommandName)
+	from, to, err := cmd.period(cliCtx)
 	if err != nil {
 		return err
 	}
-	defer tx.Rollback()
 
-	invoices, err := invoice.Generate(ctx, tx, cmd.Year, cmd.Month)
+	name, text := "invoice.html.tmpl", invoice.DefaultTemplate
+	if cmd.Template != "" {
+		raw, err := os.ReadFile(cmd.Template)
+		if err != nil {
+			return fmt.Errorf("could not read template: %w", err)
+		}
+		name, text = cmd.Template, string(raw)
+	}
+	tmpl, err := invoice.ParseTemplate(name, text)
+	if err != nil {
+		return fmt.Errorf("could not parse template: %w", err)
+	}
+
+	invoices, err := cmd.generate(cliCtx, from, to)
 	if err != nil {
 		return err
 	}
 
-	enc := json.NewEncoder(os.Stdout)
-	enc.SetEscapeHTML(false)
-	enc.SetIndent("", "\t")
-	enc.Encode(invoices)
-	return enc.Encode(invoices)
+	written, err := invoice.WriteDocuments(cmd.OutputDir, tmpl, cmd.PDF, invoices)
+	for _, path := range written {
+		log.Info("Wrote invoice", "path", path)
+	}
+	return err
+}
+
+// generate generates the invoices of the period in a read-only transaction.
+func (cmd *invoiceCommand) generate(cliCtx *cli.Context, from, to time.Time) ([]invoice.Invoice, error) {
+	ctx := cliCtx.Context
+	log := AppLogger(ctx).WithName(invoiceCommandName)
+
+	rdb, err := openDB(cliCtx, invoiceCommandName, cmd.DatabaseURL)
+	if err != nil {
+		return nil, err
+	}
+	defer rdb.Close()
+
+	log.V(1).Info("Begin transaction")
+	tx, err := rdb.BeginTxx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
 
+	return invoice.GenerateRange(ctx, tx, from, to, invoice.WithTenants(cliCtx.StringSlice("tenant")...))
 }
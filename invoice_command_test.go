@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+func TestInvoiceCommand_Period(t *testing.T) {
+	period := func(args ...string) (from, to time.Time, err error) {
+		cmd := &invoiceCommand{}
+		app := &cli.App{
+			Flags: cmd.periodFlags(),
+			Action: func(cliCtx *cli.Context) error {
+				from, to, err = cmd.period(cliCtx)
+				return nil
+			},
+		}
+		require.NoError(t, app.Run(append([]string{"test"}, args...)))
+		return from, to, err
+	}
+
+	from, to, err := period("--year", "2022", "--month", "12")
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2022, time.December, 1, 0, 0, 0, 0, time.UTC), from)
+	assert.Equal(t, time.Date(2023, time.January, 1, 0, 0, 0, 0, time.UTC), to)
+
+	from, to, err = period("--from", "2022-01-15T00:00:00Z", "--to", "2022-04-15T00:00:00Z")
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2022, time.January, 15, 0, 0, 0, 0, time.UTC), from.UTC())
+	assert.Equal(t, time.Date(2022, time.April, 15, 0, 0, 0, 0, time.UTC), to.UTC())
+
+	_, _, err = period("--year", "2022", "--month", "13")
+	assert.Error(t, err)
+	_, _, err = period("--year", "2022")
+	assert.Error(t, err)
+	_, _, err = period("--from", "2022-01-15T00:00:00Z")
+	assert.Error(t, err)
+	_, _, err = period("--year", "2022", "--month", "1", "--from", "2022-01-15T00:00:00Z", "--to", "2022-04-15T00:00:00Z")
+	assert.Error(t, err)
+	_, _, err = period()
+	assert.Error(t, err)
+}
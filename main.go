@@ -68,7 +68,15 @@ func newApp() (context.Context, context.CancelFunc, *cli.App) {
 			newMigrateCommand(),
 			newReportCommand(),
 			newCheckMissingCommand(),
+			newCheckGapsCommand(),
 			newInvoiceCommand(),
+			newQueriesCommand(),
+			newProductsCommand(),
+			newDiscountsCommand(),
+			newExchangeRatesCommand(),
+			newTenantsCommand(),
+			newPricebookCommand(),
+			newServeCommand(),
 		},
 		ExitErrHandler: func(context *cli.Context, err error) {
 			if err == nil {
@@ -2,8 +2,12 @@ package main
 
 import (
 	"fmt"
+	"io"
+	"os"
+	"time"
 
 	"github.com/appuio/appuio-cloud-reporting/pkg/db"
+	"github.com/pmezard/go-difflib/difflib"
 	"github.com/urfave/cli/v2"
 )
 
@@ -11,6 +15,9 @@ type migrateCommand struct {
 	ShowPending bool
 	DatabaseURL string
 	SeedEnabled bool
+	SeedAt      *time.Time
+	DryRun      bool
+	Repair      bool
 }
 
 var migrateCommandName = "migrate"
@@ -25,12 +32,26 @@ func newMigrateCommand() *cli.Command {
 		Flags: []cli.Flag{
 			&cli.BoolFlag{Name: "show-pending", Usage: "Shows pending migrations and exits", EnvVars: envVars("SHOW_PENDING"), Destination: &command.ShowPending},
 			&cli.BoolFlag{Name: "seed", Usage: "Seeds database with initial data and exits", EnvVars: envVars("SEED"), Destination: &command.SeedEnabled},
+			&cli.TimestampFlag{Name: "seed-at", Usage: fmt.Sprintf("Timestamp at which changed default queries are rolled over to their new version (%s)", time.RFC3339),
+				EnvVars: envVars("SEED_AT"), Layout: time.RFC3339, DefaultText: "now"},
+			&cli.BoolFlag{Name: "repair", Usage: "Link default sub-queries without parent to their parent query when seeding",
+				EnvVars: envVars("REPAIR"), Destination: &command.Repair},
+			&cli.BoolFlag{Name: "dry-run", Usage: "Print the changes to the default queries without seeding",
+				EnvVars: envVars("DRY_RUN"), Destination: &command.DryRun},
 			newDbURLFlag(&command.DatabaseURL),
 		},
 	}
 }
 
 func (cmd *migrateCommand) before(ctx *cli.Context) error {
+	if !cmd.SeedEnabled {
+		for _, name := range []string{"seed-at", "repair", "dry-run"} {
+			if ctx.IsSet(name) {
+				return fmt.Errorf("flag %q requires flag %q", name, "seed")
+			}
+		}
+	}
+	cmd.SeedAt = ctx.Timestamp("seed-at")
 	return LogMetadata(ctx)
 }
 
@@ -44,11 +65,25 @@ func (cmd *migrateCommand) execute(context *cli.Context) error {
 
 	if cmd.SeedEnabled {
 		log.V(1).Info("Seeding DB...")
-		err := db.Seed(rdb)
+		opts := db.SeedOptions{DryRun: cmd.DryRun, Repair: cmd.Repair}
+		if cmd.SeedAt != nil {
+			opts.At = *cmd.SeedAt
+		}
+		changes, err := db.Seed(rdb, opts)
 		if err != nil {
 			return fmt.Errorf("error seeding database: %w", err)
 		}
-		log.Info("Done seeding")
+		if cmd.DryRun {
+			return printSeedDiff(os.Stdout, changes)
+		}
+		for _, c := range changes {
+			if c.Action == db.SeedActionSkip {
+				log.Info("Skipped default query", "name", c.Name, "parent", c.Parent, "reason", c.Reason)
+				continue
+			}
+			log.Info("Changed default query", "name", c.Name, "action", c.Action, "parent", c.Parent)
+		}
+		log.Info("Done seeding", "changed", len(changes))
 		return nil
 	}
 
@@ -78,3 +113,29 @@ func (cmd *migrateCommand) execute(context *cli.Context) error {
 
 	return nil
 }
+
+// printSeedDiff prints a unified diff of the PromQL of every changed default query.
+func printSeedDiff(out io.Writer, changes []db.SeedChange) error {
+	for _, c := range changes {
+		switch c.Action {
+		case db.SeedActionRelink:
+			fmt.Fprintf(out, "%s: link to parent %s\n", c.Name, c.Parent)
+			continue
+		case db.SeedActionSkip:
+			fmt.Fprintf(out, "%s: skip, %s\n", c.Name, c.Reason)
+			continue
+		}
+		diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+			A:        difflib.SplitLines(c.Old),
+			B:        difflib.SplitLines(c.New),
+			FromFile: c.Name + " (current)",
+			ToFile:   c.Name + " (" + c.Action + ")",
+			Context:  3,
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Fprint(out, diff)
+	}
+	return nil
+}
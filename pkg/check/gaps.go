@@ -0,0 +1,53 @@
+package check
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/appuio/appuio-cloud-reporting/pkg/db"
+)
+
+// Gap represents an hour in which a query was valid but is not fully reported.
+type Gap struct {
+	Query     string
+	Timestamp time.Time
+
+	// NoFacts is true if there are no facts for the query in this hour.
+	NoFacts bool `db:"no_facts"`
+	// NoRun is true if there is no successful report run for the query in this hour.
+	NoRun bool `db:"no_run"`
+}
+
+var gapsQuery = db.ExpectedReportsQuery(`
+	SELECT * FROM gaps WHERE no_run OR (no_facts AND NOT $3::boolean) ORDER BY query, timestamp`,
+	`gaps AS (
+			SELECT expected.name AS query, expected.timestamp,
+					NOT EXISTS (
+						SELECT 1 FROM facts
+							INNER JOIN date_times ON (facts.date_time_id = date_times.id)
+							INNER JOIN queries ON (facts.query_id = queries.id)
+							WHERE queries.name = expected.name AND date_times.timestamp = expected.timestamp
+					) AS no_facts,
+					NOT EXISTS (
+						SELECT 1 FROM report_runs
+							WHERE report_runs.query_name = expected.name AND report_runs.timestamp = expected.timestamp AND report_runs.status = 'succeeded'
+					) AS no_run
+				FROM expected
+		)`,
+)
+
+// Gaps checks every hour in the given period for every top-level query valid during that hour.
+// Returns the hours without facts or without a successful report run, ordered by query and time.
+// If allowEmptyRuns is true, hours with a successful run but without facts are not gaps.
+func Gaps(ctx context.Context, tx sqlx.QueryerContext, from, to time.Time, allowEmptyRuns bool) ([]Gap, error) {
+	var gaps []Gap
+	if err := sqlx.SelectContext(ctx, tx, &gaps, gapsQuery, from, to, allowEmptyRuns); err != nil {
+		return nil, err
+	}
+	for i := range gaps {
+		gaps[i].Timestamp = gaps[i].Timestamp.In(time.UTC)
+	}
+	return gaps, nil
+}
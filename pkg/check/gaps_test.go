@@ -0,0 +1,70 @@
+package check_test
+
+import (
+	"context"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/appuio/appuio-cloud-reporting/pkg/check"
+	"github.com/appuio/appuio-cloud-reporting/pkg/db"
+)
+
+func (s *TestSuite) TestGaps() {
+	t := s.T()
+	tx := s.Begin()
+	defer tx.Rollback()
+
+	base := time.Date(2020, time.January, 23, 17, 0, 0, 0, time.UTC)
+
+	query, err := db.CreateQuery(tx, db.Query{
+		Name:   "gaps_test",
+		Query:  "vector(1)",
+		Unit:   "tps",
+		During: db.Timerange(db.MustTimestamp(base), db.MustTimestamp(base.Add(3*time.Hour))),
+	})
+	require.NoError(t, err)
+
+	var tenant db.Tenant
+	require.NoError(t, db.GetNamed(tx, &tenant, "INSERT INTO tenants (source) VALUES (:source) RETURNING *", db.Tenant{Source: "gaps_tenant"}))
+	var category db.Category
+	require.NoError(t, db.GetNamed(tx, &category, "INSERT INTO categories (source) VALUES (:source) RETURNING *", db.Category{Source: "gaps_category"}))
+	product, err := db.CreateProduct(tx, db.Product{Source: "gaps_test", During: db.InfiniteRange()})
+	require.NoError(t, err)
+	discount, err := db.CreateDiscount(tx, db.Discount{Source: "gaps_test", During: db.InfiniteRange()})
+	require.NoError(t, err)
+	var dateTime db.DateTime
+	require.NoError(t, db.GetNamed(tx, &dateTime,
+		"INSERT INTO date_times (timestamp,year,month,day,hour) VALUES (:timestamp,:year,:month,:day,:hour) RETURNING *", db.BuildDateTime(base)))
+	_, err = tx.NamedExec("INSERT INTO facts (date_time_id,query_id,tenant_id,category_id,product_id,discount_id,quantity) VALUES (:date_time_id,:query_id,:tenant_id,:category_id,:product_id,:discount_id,:quantity)",
+		db.Fact{DateTimeId: dateTime.Id, QueryId: query.Id, TenantId: tenant.Id, CategoryId: category.Id, ProductId: product.Id, DiscountId: discount.Id, Quantity: 1})
+	require.NoError(t, err)
+
+	for _, ts := range []time.Time{base, base.Add(time.Hour)} {
+		_, err := tx.Exec("INSERT INTO report_runs (query_name,timestamp,status,started_at) VALUES ($1,$2,'succeeded',now())", query.Name, ts)
+		require.NoError(t, err)
+	}
+
+	gaps, err := check.Gaps(context.Background(), tx, base.Add(-time.Hour), base.Add(4*time.Hour), false)
+	require.NoError(t, err)
+	require.Equal(t, []check.Gap{
+		{Query: query.Name, Timestamp: base.Add(time.Hour), NoFacts: true},
+		{Query: query.Name, Timestamp: base.Add(2 * time.Hour), NoFacts: true, NoRun: true},
+	}, queryGaps(gaps, query.Name))
+
+	gaps, err = check.Gaps(context.Background(), tx, base.Add(-time.Hour), base.Add(4*time.Hour), true)
+	require.NoError(t, err)
+	require.Equal(t, []check.Gap{
+		{Query: query.Name, Timestamp: base.Add(2 * time.Hour), NoFacts: true, NoRun: true},
+	}, queryGaps(gaps, query.Name), "hours with a successful run but without facts should be allowed")
+}
+
+func queryGaps(gaps []check.Gap, name string) []check.Gap {
+	filtered := make([]check.Gap, 0)
+	for _, g := range gaps {
+		if g.Query == name {
+			filtered = append(filtered, g)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,96 @@
+package check
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/appuio/appuio-cloud-reporting/pkg/db"
+	"github.com/appuio/appuio-cloud-reporting/pkg/promql"
+)
+
+// QueryIssue represents a problem with a query which would make its reports fail or miss data.
+type QueryIssue struct {
+	Query  string
+	During string
+	Issue  string
+}
+
+const subQueryIssuesQuery = `
+	SELECT sub.id,
+			CASE
+				WHEN parent.parent_id IS NOT NULL THEN 'parent ' || parent.name || ' is a sub-query itself, nested sub-queries are never run'
+				ELSE 'not valid during its parent ' || parent.name || ', sub-query is never run'
+			END AS issue
+		FROM queries AS sub
+		INNER JOIN queries AS parent ON (sub.parent_id = parent.id)
+		WHERE parent.parent_id IS NOT NULL OR NOT (sub.during && parent.during)
+`
+
+// Queries checks the syntax of all queries, whether they can produce the labels required to build the source key and category of a fact, and whether sub-queries are linked to a valid parent.
+// Templates are rendered with the query parameters valid at the given time.
+// Returns the issues ordered by query name and start of their validity.
+func Queries(ctx context.Context, tx sqlx.QueryerContext, at time.Time) ([]QueryIssue, error) {
+	queries, err := db.ListQueries(ctx, tx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load queries: %w", err)
+	}
+	params, err := db.QueryParametersAt(ctx, tx, at)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load query parameters: %w", err)
+	}
+
+	var subQueryIssues []struct {
+		Id    string
+		Issue string
+	}
+	if err := sqlx.SelectContext(ctx, tx, &subQueryIssues, subQueryIssuesQuery); err != nil {
+		return nil, fmt.Errorf("failed to check sub-queries: %w", err)
+	}
+	issuesByID := map[string][]string{}
+	for _, i := range subQueryIssues {
+		issuesByID[i.Id] = append(issuesByID[i.Id], i.Issue)
+	}
+
+	issues := make([]QueryIssue, 0)
+	for _, q := range queries {
+		for _, issue := range append(queryIssues(q, params), issuesByID[q.Id]...) {
+			issues = append(issues, QueryIssue{Query: q.Name, During: db.FormatTimerange(q.During), Issue: issue})
+		}
+	}
+	return issues, nil
+}
+
+// queryIssues returns the syntax and label issues of a single query.
+func queryIssues(q db.Query, params map[string]string) []string {
+	rendered, err := promql.Render(q.Name, q.Query, params)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	expr, err := promql.Parse(rendered)
+	if err != nil {
+		return []string{err.Error()}
+	}
+
+	labels := q.QueryLabels
+	var required []string
+	if labels.Direct() {
+		required = []string{labels.ZoneLabel, labels.TenantLabel, labels.NamespaceLabel}
+	} else {
+		required = []string{orDefault(labels.CategoryLabel, "category"), orDefault(labels.ProductLabel, "product")}
+	}
+	if missing := promql.MissingLabels(expr, required...); len(missing) > 0 {
+		return []string{fmt.Sprintf("can't produce the label(s) %s", strings.Join(missing, ", "))}
+	}
+	return nil
+}
+
+func orDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
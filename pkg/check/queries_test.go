@@ -0,0 +1,77 @@
+package check_test
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/jackc/pgtype"
+	"github.com/stretchr/testify/require"
+
+	"github.com/appuio/appuio-cloud-reporting/pkg/check"
+	"github.com/appuio/appuio-cloud-reporting/pkg/db"
+)
+
+func (s *TestSuite) TestQueries() {
+	t := s.T()
+	tx := s.Begin()
+	defer tx.Rollback()
+
+	base := time.Date(2020, time.January, 23, 17, 0, 0, 0, time.UTC)
+
+	valid, err := db.CreateQuery(tx, db.Query{
+		Name:   "check_valid",
+		Query:  `label_replace(label_replace(vector({{ .check_value }}), "category", "c", "", ""), "product", "p:z:t:n", "", "")`,
+		During: db.Timerange(db.MustTimestamp(base), db.MustTimestamp(base.Add(time.Hour))),
+	})
+	require.NoError(t, err)
+	_, err = db.CreateQueryParameter(tx, db.QueryParameter{Name: "check_value", Value: "1",
+		During: db.Timerange(db.MustTimestamp(base), db.MustTimestamp(pgtype.Infinity))})
+	require.NoError(t, err)
+
+	_, err = db.CreateQuery(tx, db.Query{
+		Name:   "check_no_product",
+		Query:  `sum by (category) (up)`,
+		During: db.InfiniteRange(),
+	})
+	require.NoError(t, err)
+	_, err = db.CreateQuery(tx, db.Query{
+		Name:        "check_direct",
+		Query:       `sum by (cluster_id, namespace) (up) * on(cluster_id, namespace) group_left(tenant_id) kube_namespace_labels`,
+		During:      db.InfiniteRange(),
+		QueryLabels: db.QueryLabels{ZoneLabel: "cluster_id", TenantLabel: "tenant_id", NamespaceLabel: "namespace"},
+	})
+	require.NoError(t, err)
+	_, err = db.CreateQuery(tx, db.Query{
+		Name:     "check_sub",
+		ParentID: sql.NullString{String: valid.Id, Valid: true},
+		Query:    `sum by (category, product) (up)`,
+		During:   db.Timerange(db.MustTimestamp(base.Add(time.Hour)), db.MustTimestamp(base.Add(2*time.Hour))),
+	})
+	require.NoError(t, err)
+
+	_, err = db.CreateQuery(tx, db.Query{Name: "check_invalid", Query: "sum(up"})
+	require.ErrorIs(t, err, db.ErrInvalidQuery)
+
+	issues, err := check.Queries(context.Background(), tx, base)
+	require.NoError(t, err)
+	byQuery := map[string][]string{}
+	for _, i := range issues {
+		byQuery[i.Query] = append(byQuery[i.Query], i.Issue)
+	}
+	require.Empty(t, byQuery["check_valid"])
+	require.Empty(t, byQuery["check_direct"])
+	require.Equal(t, []string{"can't produce the label(s) product"}, byQuery["check_no_product"])
+	require.Equal(t, []string{"not valid during its parent check_valid, sub-query is never run"}, byQuery["check_sub"])
+
+	issues, err = check.Queries(context.Background(), tx, base.Add(-time.Hour))
+	require.NoError(t, err)
+	found := false
+	for _, i := range issues {
+		if i.Query == "check_valid" {
+			require.Contains(t, i.Issue, "check_value", "templates should be rendered with the parameters valid at the given time")
+			found = true
+		}
+	}
+	require.True(t, found)
+}
@@ -0,0 +1,74 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ListDiscounts returns all discounts ordered by source and start of their validity.
+// If at is not nil, only discounts valid at the given time are returned.
+func ListDiscounts(ctx context.Context, q sqlx.QueryerContext, at *time.Time) ([]Discount, error) {
+	var discounts []Discount
+	err := sqlx.SelectContext(ctx, q, &discounts,
+		`SELECT * FROM discounts
+			WHERE $1::timestamptz IS NULL OR during @> $1::timestamptz
+			ORDER BY source, lower(during)`,
+		at)
+	return discounts, err
+}
+
+// UpdateDiscount updates all fields of the discount with the id of the given discount.
+func UpdateDiscount(ctx context.Context, p NamedPreparerContext, in Discount) (Discount, error) {
+	var discount Discount
+	err := GetNamedContext(ctx, p, &discount,
+		"UPDATE discounts SET source = :source, discount = :discount, during = :during WHERE id = :id RETURNING *", in)
+	if errors.Is(err, sql.ErrNoRows) {
+		return discount, fmt.Errorf("no discount with id %q found", in.Id)
+	}
+	return discount, translateError(err)
+}
+
+// DeleteDiscount deletes the discount with the given id.
+// Discounts still referenced by facts can't be deleted.
+func DeleteDiscount(ctx context.Context, e sqlx.ExecerContext, id string) error {
+	res, err := e.ExecContext(ctx, "DELETE FROM discounts WHERE id = $1", id)
+	if err != nil {
+		return translateDeleteError(err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("no discount with id %q found", id)
+	}
+	return nil
+}
+
+// SupersedeDiscount ends the validity of the discount with the given source valid at the given time and creates a new discount starting at that time.
+// The new discount is valid until the end of the superseded discount's validity.
+// Source and During of next are ignored.
+// Returns the superseded and the newly created discount.
+func SupersedeDiscount(ctx context.Context, tx *sqlx.Tx, source string, at time.Time, next Discount) (superseded Discount, created Discount, err error) {
+	err = sqlx.GetContext(ctx, tx, &superseded,
+		"SELECT * FROM discounts WHERE source = $1 AND during @> $2::timestamptz AND lower(during) < $2::timestamptz FOR UPDATE",
+		source, at)
+	if errors.Is(err, sql.ErrNoRows) {
+		return superseded, created, fmt.Errorf("no discount with source %q valid before and at %s found", source, at.Format(time.RFC3339))
+	} else if err != nil {
+		return superseded, created, err
+	}
+
+	superseded.During, next.During = splitTimerange(superseded.During, at)
+	next.Source = superseded.Source
+
+	if superseded, err = UpdateDiscount(ctx, tx, superseded); err != nil {
+		return superseded, created, fmt.Errorf("failed to supersede discount: %w", err)
+	}
+	if err := GetNamedContext(ctx, tx, &created,
+		"INSERT INTO discounts (source,discount,during) VALUES (:source,:discount,:during) RETURNING *", next); err != nil {
+		return superseded, created, fmt.Errorf("failed to create discount: %w", translateError(err))
+	}
+	return superseded, created, nil
+}
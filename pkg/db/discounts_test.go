@@ -0,0 +1,54 @@
+package db_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/appuio/appuio-cloud-reporting/pkg/db"
+	"github.com/appuio/appuio-cloud-reporting/pkg/db/dbtest"
+)
+
+type DiscountsTestSuite struct {
+	dbtest.Suite
+}
+
+func (s *DiscountsTestSuite) TestDiscounts_Supersede() {
+	t := s.T()
+	ctx := context.Background()
+	tx := s.Begin()
+	defer tx.Rollback()
+
+	_, err := db.CreateDiscount(tx, db.Discount{
+		Source:   "test_supersede",
+		Discount: 0.1,
+		During:   db.InfiniteRange(),
+	})
+	require.NoError(t, err)
+
+	at := time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC)
+	superseded, created, err := db.SupersedeDiscount(ctx, tx, "test_supersede", at, db.Discount{Discount: 0.2})
+	require.NoError(t, err)
+	require.Equal(t, "[-infinity,2022-01-01T00:00:00Z)", db.FormatTimerange(superseded.During))
+	require.Equal(t, "[2022-01-01T00:00:00Z,infinity)", db.FormatTimerange(created.During))
+	require.Equal(t, 0.1, superseded.Discount)
+	require.Equal(t, 0.2, created.Discount)
+
+	valid, err := db.ListDiscounts(ctx, tx, &at)
+	require.NoError(t, err)
+	require.Len(t, valid, 1)
+	require.Equal(t, created.Id, valid[0].Id)
+
+	_, err = db.CreateDiscount(tx, db.Discount{Source: "test_supersede", During: db.InfiniteRange()})
+	require.ErrorIs(t, err, db.ErrOverlappingTimerange)
+
+	require.NoError(t, db.DeleteDiscount(ctx, tx, created.Id))
+	require.Error(t, db.DeleteDiscount(ctx, tx, created.Id))
+}
+
+func TestDiscounts(t *testing.T) {
+	suite.Run(t, new(DiscountsTestSuite))
+}
@@ -0,0 +1,73 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgconn"
+
+	"github.com/appuio/appuio-cloud-reporting/pkg/promql"
+)
+
+const (
+	foreignKeyViolation  = "23503"
+	exclusionViolation   = "23P01"
+	serializationFailure = "40001"
+	deadlockDetected     = "40P01"
+)
+
+var (
+	// ErrOverlappingTimerange is returned if the `during` timerange of an entry overlaps with an existing entry with the same key.
+	ErrOverlappingTimerange = errors.New("timerange overlaps with an existing entry")
+	// ErrStillReferenced is returned if an entry can't be deleted because it is still referenced by other entries.
+	ErrStillReferenced = errors.New("entry is still referenced")
+	// ErrInvalidQuery is returned if the PromQL or template of a query can't be parsed.
+	ErrInvalidQuery = errors.New("invalid query")
+)
+
+// overlapDescriptions holds readable descriptions of the keys guarded by the non-overlapping exclusion constraints.
+var overlapDescriptions = map[string]string{
+	"queries_name_unit_during_non_overlapping":         "a query with the same name and unit",
+	"products_source_during_non_overlapping":           "a product with the same source",
+	"discounts_source_during_non_overlapping":          "a discount with the same source",
+	"query_parameters_name_during_non_overlapping":     "a query parameter with the same name",
+	"exchange_rates_currencies_during_non_overlapping": "an exchange rate with the same currencies",
+}
+
+// validateQuery checks the syntax of the PromQL or template of the query.
+func validateQuery(q Query) error {
+	if err := promql.Validate(q.Query); err != nil {
+		return fmt.Errorf("%w %q: %s", ErrInvalidQuery, q.Name, err)
+	}
+	return nil
+}
+
+// IsTransactionConflict returns true if the transaction was aborted because of a conflict with a concurrent transaction.
+// Such transactions can be retried.
+func IsTransactionConflict(err error) bool {
+	pgErr := &pgconn.PgError{}
+	return errors.As(err, &pgErr) && (pgErr.Code == serializationFailure || pgErr.Code == deadlockDetected)
+}
+
+// translateError translates violations of the non-overlapping timerange constraints into readable errors.
+// Other errors are returned unchanged.
+func translateError(err error) error {
+	pgErr := &pgconn.PgError{}
+	if !errors.As(err, &pgErr) || pgErr.Code != exclusionViolation {
+		return err
+	}
+	if desc, ok := overlapDescriptions[pgErr.ConstraintName]; ok {
+		return fmt.Errorf("%w: %s already exists in an overlapping timerange", ErrOverlappingTimerange, desc)
+	}
+	return fmt.Errorf("%w: %s", ErrOverlappingTimerange, pgErr.ConstraintName)
+}
+
+// translateDeleteError translates foreign key violations when deleting an entry into readable errors.
+// Other errors are passed to translateError.
+func translateDeleteError(err error) error {
+	pgErr := &pgconn.PgError{}
+	if errors.As(err, &pgErr) && pgErr.Code == foreignKeyViolation {
+		return fmt.Errorf("%w by %s", ErrStillReferenced, pgErr.TableName)
+	}
+	return translateError(err)
+}
@@ -0,0 +1,33 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ListExchangeRates returns all exchange rates ordered by their currencies and start of their validity.
+// If at is not nil, only exchange rates valid at the given time are returned.
+func ListExchangeRates(ctx context.Context, q sqlx.QueryerContext, at *time.Time) ([]ExchangeRate, error) {
+	var rates []ExchangeRate
+	err := sqlx.SelectContext(ctx, q, &rates,
+		`SELECT * FROM exchange_rates
+			WHERE $1::timestamptz IS NULL OR during @> $1::timestamptz
+			ORDER BY source_currency, target_currency, lower(during)`,
+		at)
+	return rates, err
+}
+
+// DeleteExchangeRate deletes the exchange rate with the given id.
+func DeleteExchangeRate(ctx context.Context, e sqlx.ExecerContext, id string) error {
+	res, err := e.ExecContext(ctx, "DELETE FROM exchange_rates WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("no exchange rate with id %q found", id)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package db_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgtype"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/appuio/appuio-cloud-reporting/pkg/db"
+	"github.com/appuio/appuio-cloud-reporting/pkg/db/dbtest"
+)
+
+type ExchangeRatesTestSuite struct {
+	dbtest.Suite
+}
+
+func (s *ExchangeRatesTestSuite) TestExchangeRates_CreateListDelete() {
+	t := s.T()
+	ctx := context.Background()
+	tx := s.Begin()
+	defer tx.Rollback()
+
+	switchAt := time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC)
+	old, err := db.CreateExchangeRate(tx, db.ExchangeRate{
+		SourceCurrency: "EUR",
+		TargetCurrency: db.DefaultCurrency,
+		Rate:           1.1,
+		During:         db.Timerange(db.MustTimestamp(pgtype.NegativeInfinity), db.MustTimestamp(switchAt)),
+	})
+	require.NoError(t, err)
+	current, err := db.CreateExchangeRate(tx, db.ExchangeRate{
+		SourceCurrency: "EUR",
+		TargetCurrency: db.DefaultCurrency,
+		Rate:           0.9,
+		During:         db.Timerange(db.MustTimestamp(switchAt), db.MustTimestamp(pgtype.Infinity)),
+	})
+	require.NoError(t, err)
+
+	valid, err := db.ListExchangeRates(ctx, tx, &switchAt)
+	require.NoError(t, err)
+	require.Len(t, valid, 1)
+	require.Equal(t, current.Id, valid[0].Id)
+	require.Equal(t, 0.9, valid[0].Rate)
+
+	all, err := db.ListExchangeRates(ctx, tx, nil)
+	require.NoError(t, err)
+	require.Len(t, all, 2)
+	require.Equal(t, old.Id, all[0].Id)
+
+	_, err = db.CreateExchangeRate(tx, db.ExchangeRate{SourceCurrency: "EUR", TargetCurrency: db.DefaultCurrency, Rate: 1, During: db.InfiniteRange()})
+	require.ErrorIs(t, err, db.ErrOverlappingTimerange)
+
+	require.NoError(t, db.DeleteExchangeRate(ctx, tx, current.Id))
+	require.Error(t, db.DeleteExchangeRate(ctx, tx, current.Id))
+}
+
+func TestExchangeRates(t *testing.T) {
+	suite.Run(t, new(ExchangeRatesTestSuite))
+}
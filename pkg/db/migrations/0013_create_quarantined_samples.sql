@@ -0,0 +1,14 @@
+CREATE TABLE quarantined_samples (
+  id            uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  query_id      uuid NOT NULL,
+  timestamp     timestamp with time zone NOT NULL,
+  labels        jsonb NOT NULL,
+  value         double precision NOT NULL,
+  reason        text NOT NULL DEFAULT '',
+
+  CONSTRAINT fk_query
+    FOREIGN KEY(query_id)
+    REFERENCES queries(id),
+
+  UNIQUE(query_id,timestamp,labels)
+)
@@ -0,0 +1,5 @@
+CREATE TABLE scheduler_state (
+  -- Only a single row is allowed
+  id                    boolean PRIMARY KEY DEFAULT true CHECK (id),
+  last_successful_hour  timestamp with time zone NOT NULL
+)
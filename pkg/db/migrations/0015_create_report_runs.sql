@@ -0,0 +1,14 @@
+CREATE TABLE report_runs (
+  id                uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  query_name        text NOT NULL,
+  timestamp         timestamp with time zone NOT NULL,
+  status            text NOT NULL CHECK (status IN ('succeeded', 'failed')),
+  samples           integer NOT NULL DEFAULT 0,
+  started_at        timestamp with time zone NOT NULL,
+  duration_seconds  double precision NOT NULL DEFAULT 0,
+  warnings          text[] NOT NULL DEFAULT '{}',
+  error             text NOT NULL DEFAULT '',
+  version           text NOT NULL DEFAULT ''
+);
+
+CREATE INDEX report_runs_query_name_timestamp ON report_runs (query_name, timestamp);
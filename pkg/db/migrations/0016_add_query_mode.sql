@@ -0,0 +1,4 @@
+ALTER TABLE queries
+  ADD COLUMN mode text NOT NULL DEFAULT 'instant' CHECK (mode IN ('instant', 'range')),
+  ADD COLUMN range_step_seconds integer NOT NULL DEFAULT 60 CHECK (range_step_seconds > 0),
+  ADD COLUMN range_aggregation text NOT NULL DEFAULT 'avg' CHECK (range_aggregation IN ('avg', 'max', 'sum'));
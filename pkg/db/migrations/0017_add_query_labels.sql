@@ -0,0 +1,7 @@
+ALTER TABLE queries
+  ADD COLUMN category_label text NOT NULL DEFAULT 'category',
+  ADD COLUMN product_label text NOT NULL DEFAULT 'product',
+  ADD COLUMN zone_label text NOT NULL DEFAULT '',
+  ADD COLUMN tenant_label text NOT NULL DEFAULT '',
+  ADD COLUMN namespace_label text NOT NULL DEFAULT '',
+  ADD COLUMN class_label text NOT NULL DEFAULT '';
@@ -0,0 +1,10 @@
+CREATE TABLE query_parameters (
+  id        uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  name      text NOT NULL,
+  value     text NOT NULL,
+  during    tstzrange NOT NULL DEFAULT '[-infinity,infinity)',
+
+  CONSTRAINT query_parameters_name_during_non_overlapping EXCLUDE USING GIST (name WITH =, during WITH &&),
+  CONSTRAINT query_parameters_during_lower_not_null_ck CHECK (lower(during) IS NOT NULL),
+  CONSTRAINT query_parameters_during_upper_not_null_ck CHECK (upper(during) IS NOT NULL)
+);
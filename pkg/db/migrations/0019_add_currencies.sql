@@ -0,0 +1,24 @@
+ALTER TABLE products
+  ADD COLUMN currency text NOT NULL DEFAULT 'CHF',
+  ADD CONSTRAINT products_currency_ck CHECK (currency ~ '^[A-Z]{3}$');
+
+ALTER TABLE tenants
+  ADD COLUMN billing_currency text NOT NULL DEFAULT 'CHF',
+  ADD CONSTRAINT tenants_billing_currency_ck CHECK (billing_currency ~ '^[A-Z]{3}$');
+
+CREATE TABLE exchange_rates (
+  id              uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  source_currency text NOT NULL,
+  target_currency text NOT NULL,
+  -- rate is the amount in the target currency of one unit of the source currency
+  rate            double precision NOT NULL,
+  during          tstzrange NOT NULL DEFAULT '[-infinity,infinity)',
+
+  CONSTRAINT exchange_rates_currencies_during_non_overlapping EXCLUDE USING GIST (source_currency WITH =, target_currency WITH =, during WITH &&),
+  CONSTRAINT exchange_rates_during_lower_not_null_ck CHECK (lower(during) IS NOT NULL),
+  CONSTRAINT exchange_rates_during_upper_not_null_ck CHECK (upper(during) IS NOT NULL),
+  CONSTRAINT exchange_rates_source_currency_ck CHECK (source_currency ~ '^[A-Z]{3}$'),
+  CONSTRAINT exchange_rates_target_currency_ck CHECK (target_currency ~ '^[A-Z]{3}$'),
+  CONSTRAINT exchange_rates_different_currencies_ck CHECK (source_currency <> target_currency),
+  CONSTRAINT exchange_rates_rate_positive_ck CHECK (rate > 0)
+);
@@ -0,0 +1,93 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ListProducts returns all products ordered by source and start of their validity.
+// If at is not nil, only products valid at the given time are returned.
+func ListProducts(ctx context.Context, q sqlx.QueryerContext, at *time.Time) ([]Product, error) {
+	var products []Product
+	err := sqlx.SelectContext(ctx, q, &products,
+		`SELECT * FROM products
+			WHERE $1::timestamptz IS NULL OR during @> $1::timestamptz
+			ORDER BY source, lower(during)`,
+		at)
+	return products, err
+}
+
+// GetProduct returns the product with the given id.
+func GetProduct(ctx context.Context, q sqlx.QueryerContext, id string) (Product, error) {
+	var product Product
+	err := sqlx.GetContext(ctx, q, &product, "SELECT * FROM products WHERE id = $1", id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return product, fmt.Errorf("no product with id %q found", id)
+	}
+	return product, err
+}
+
+// UpdateProduct updates all fields of the product with the id of the given product.
+func UpdateProduct(ctx context.Context, p NamedPreparerContext, in Product) (Product, error) {
+	var product Product
+	err := GetNamedContext(ctx, p, &product,
+		"UPDATE products SET source = :source, target = :target, amount = :amount, unit = :unit, currency = :currency, during = :during WHERE id = :id RETURNING *", in.withDefaults())
+	if errors.Is(err, sql.ErrNoRows) {
+		return product, fmt.Errorf("no product with id %q found", in.Id)
+	}
+	return product, translateError(err)
+}
+
+// DeleteProduct deletes the product with the given id.
+// Products still referenced by facts can't be deleted.
+func DeleteProduct(ctx context.Context, e sqlx.ExecerContext, id string) error {
+	res, err := e.ExecContext(ctx, "DELETE FROM products WHERE id = $1", id)
+	if err != nil {
+		return translateDeleteError(err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("no product with id %q found", id)
+	}
+	return nil
+}
+
+// CloseProduct ends the validity of the product with the given source valid at the given time and creates a new product starting at that time.
+// The new product is valid until the end of the closed product's validity.
+// Source and During of next are ignored; empty Unit and Currency and invalid Target are copied from the closed product.
+// Returns the closed and the newly created product.
+func CloseProduct(ctx context.Context, tx *sqlx.Tx, source string, at time.Time, next Product) (closed Product, created Product, err error) {
+	err = sqlx.GetContext(ctx, tx, &closed,
+		"SELECT * FROM products WHERE source = $1 AND during @> $2::timestamptz AND lower(during) < $2::timestamptz FOR UPDATE",
+		source, at)
+	if errors.Is(err, sql.ErrNoRows) {
+		return closed, created, fmt.Errorf("no product with source %q valid before and at %s found", source, at.Format(time.RFC3339))
+	} else if err != nil {
+		return closed, created, err
+	}
+
+	closed.During, next.During = splitTimerange(closed.During, at)
+	next.Source = closed.Source
+	if next.Unit == "" {
+		next.Unit = closed.Unit
+	}
+	if next.Currency == "" {
+		next.Currency = closed.Currency
+	}
+	if !next.Target.Valid {
+		next.Target = closed.Target
+	}
+
+	if closed, err = UpdateProduct(ctx, tx, closed); err != nil {
+		return closed, created, fmt.Errorf("failed to close product: %w", err)
+	}
+	if err := GetNamedContext(ctx, tx, &created,
+		"INSERT INTO products (source,target,amount,unit,currency,during) VALUES (:source,:target,:amount,:unit,:currency,:during) RETURNING *", next.withDefaults()); err != nil {
+		return closed, created, fmt.Errorf("failed to create product: %w", translateError(err))
+	}
+	return closed, created, nil
+}
@@ -0,0 +1,98 @@
+package db_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgtype"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/appuio/appuio-cloud-reporting/pkg/db"
+	"github.com/appuio/appuio-cloud-reporting/pkg/db/dbtest"
+)
+
+type ProductsTestSuite struct {
+	dbtest.Suite
+}
+
+func (s *ProductsTestSuite) TestProducts_CreateOverlapping() {
+	t := s.T()
+	tx := s.Begin()
+	defer tx.Rollback()
+
+	_, err := db.CreateProduct(tx, db.Product{Source: "test", During: db.InfiniteRange()})
+	require.NoError(t, err)
+	_, err = db.CreateProduct(tx, db.Product{Source: "test", During: db.InfiniteRange()})
+	require.ErrorIs(t, err, db.ErrOverlappingTimerange)
+}
+
+func (s *ProductsTestSuite) TestProducts_ListUpdateDelete() {
+	t := s.T()
+	ctx := context.Background()
+	tx := s.Begin()
+	defer tx.Rollback()
+
+	at := time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC)
+	p, err := db.CreateProduct(tx, db.Product{
+		Source: "test_list",
+		Amount: 1,
+		During: db.Timerange(db.MustTimestamp(at), db.MustTimestamp(pgtype.Infinity)),
+	})
+	require.NoError(t, err)
+
+	valid, err := db.ListProducts(ctx, tx, &at)
+	require.NoError(t, err)
+	require.Len(t, valid, 1)
+	before := at.Add(-time.Hour)
+	valid, err = db.ListProducts(ctx, tx, &before)
+	require.NoError(t, err)
+	require.Len(t, valid, 0)
+
+	p.Amount = 2
+	p.Target = sql.NullString{String: "1234", Valid: true}
+	updated, err := db.UpdateProduct(ctx, tx, p)
+	require.NoError(t, err)
+	require.Equal(t, float64(2), updated.Amount)
+	require.Equal(t, "1234", updated.Target.String)
+
+	require.NoError(t, db.DeleteProduct(ctx, tx, p.Id))
+	_, err = db.GetProduct(ctx, tx, p.Id)
+	require.Error(t, err)
+	require.Error(t, db.DeleteProduct(ctx, tx, p.Id))
+}
+
+func (s *ProductsTestSuite) TestProducts_Close() {
+	t := s.T()
+	ctx := context.Background()
+	tx := s.Begin()
+	defer tx.Rollback()
+
+	_, err := db.CreateProduct(tx, db.Product{
+		Source: "test_close",
+		Target: sql.NullString{String: "1234", Valid: true},
+		Amount: 1,
+		Unit:   "MiB",
+		During: db.InfiniteRange(),
+	})
+	require.NoError(t, err)
+
+	at := time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC)
+	closed, created, err := db.CloseProduct(ctx, tx, "test_close", at, db.Product{Amount: 2})
+	require.NoError(t, err)
+	require.Equal(t, "[-infinity,2022-01-01T00:00:00Z)", db.FormatTimerange(closed.During))
+	require.Equal(t, "[2022-01-01T00:00:00Z,infinity)", db.FormatTimerange(created.During))
+	require.Equal(t, "test_close", created.Source)
+	require.Equal(t, "1234", created.Target.String)
+	require.Equal(t, "MiB", created.Unit)
+	require.Equal(t, float64(2), created.Amount)
+
+	_, _, err = db.CloseProduct(ctx, tx, "test_close", at, db.Product{Amount: 3})
+	require.Error(t, err, "closing a product at its lower bound should fail")
+}
+
+func TestProducts(t *testing.T) {
+	suite.Run(t, new(ProductsTestSuite))
+}
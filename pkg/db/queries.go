@@ -0,0 +1,151 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgtype"
+	"github.com/jmoiron/sqlx"
+)
+
+// ListQueries returns all queries ordered by name and start of their validity.
+// If at is not nil, only queries valid at the given time are returned.
+func ListQueries(ctx context.Context, q sqlx.QueryerContext, at *time.Time) ([]Query, error) {
+	var queries []Query
+	err := sqlx.SelectContext(ctx, q, &queries,
+		`SELECT * FROM queries
+			WHERE $1::timestamptz IS NULL OR during @> $1::timestamptz
+			ORDER BY name, lower(during)`,
+		at)
+	return queries, err
+}
+
+// GetQueryAt returns the query with the given name valid at the given time.
+// Sub-queries are not considered.
+func GetQueryAt(ctx context.Context, q sqlx.QueryerContext, name string, at time.Time) (Query, error) {
+	var query Query
+	err := sqlx.GetContext(ctx, q, &query,
+		"SELECT * FROM queries WHERE parent_id IS NULL AND name = $1 AND during @> $2::timestamptz", name, at)
+	if errors.Is(err, sql.ErrNoRows) {
+		return query, fmt.Errorf("no query with name %q valid at %s found", name, at.Format(time.RFC3339))
+	}
+	return query, err
+}
+
+// ListSubQueries returns the sub-queries of the query with the given id ordered by name and start of their validity.
+// If at is not nil, only sub-queries valid at the given time are returned.
+func ListSubQueries(ctx context.Context, q sqlx.QueryerContext, parentID string, at *time.Time) ([]Query, error) {
+	var queries []Query
+	err := sqlx.SelectContext(ctx, q, &queries,
+		`SELECT * FROM queries
+			WHERE parent_id = $1 AND ($2::timestamptz IS NULL OR during @> $2::timestamptz)
+			ORDER BY name, lower(during)`,
+		parentID, at)
+	return queries, err
+}
+
+// UpdateQuery updates all fields of the query with the id of the given query.
+// Returns ErrInvalidQuery if the PromQL of the query was changed and can't be parsed.
+// Unchanged PromQL is not validated again so existing queries can always be closed or moved.
+func UpdateQuery(ctx context.Context, p NamedPreparerContext, in Query) (Query, error) {
+	var query Query
+	var stored string
+	err := GetNamedContext(ctx, p, &stored, `SELECT query FROM queries WHERE id = :id`, in)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return query, translateError(err)
+	}
+	if err != nil || stored != in.Query {
+		if err := validateQuery(in); err != nil {
+			return query, err
+		}
+	}
+	err = GetNamedContext(ctx, p, &query,
+		`UPDATE queries SET
+				parent_id = :parent_id, name = :name, description = :description, query = :query, unit = :unit, during = :during,
+				mode = :mode, range_step_seconds = :range_step_seconds, range_aggregation = :range_aggregation,
+				category_label = :category_label, product_label = :product_label, zone_label = :zone_label,
+				tenant_label = :tenant_label, namespace_label = :namespace_label, class_label = :class_label
+			WHERE id = :id RETURNING *`, in.WithDefaults())
+	if errors.Is(err, sql.ErrNoRows) {
+		return query, fmt.Errorf("no query with id %q found", in.Id)
+	}
+	return query, translateError(err)
+}
+
+// DeleteQuery deletes the query with the given id.
+// Queries still referenced by facts or sub-queries can't be deleted.
+func DeleteQuery(ctx context.Context, e sqlx.ExecerContext, id string) error {
+	res, err := e.ExecContext(ctx, "DELETE FROM queries WHERE id = $1", id)
+	if err != nil {
+		return translateDeleteError(err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("no query with id %q found", id)
+	}
+	return nil
+}
+
+// RolloverQuery ends the validity of the query with the given name valid at the given time and creates a new version with the given PromQL starting at that time.
+// The new version is valid until the end of the closed version's validity and copies all other fields of it.
+// Sub-queries of the closed version still valid at or after the given time are carried over to the new version.
+// Sub-queries valid at the given time are split like their parent, later ones are moved.
+// Returns the closed and the newly created query and the carried over sub-queries.
+func RolloverQuery(ctx context.Context, tx *sqlx.Tx, name string, at time.Time, promQL string) (closed Query, created Query, carried []Query, err error) {
+	err = sqlx.GetContext(ctx, tx, &closed,
+		"SELECT * FROM queries WHERE parent_id IS NULL AND name = $1 AND during @> $2::timestamptz AND lower(during) < $2::timestamptz FOR UPDATE",
+		name, at)
+	if errors.Is(err, sql.ErrNoRows) {
+		return closed, created, carried, fmt.Errorf("no query with name %q valid before and at %s found", name, at.Format(time.RFC3339))
+	} else if err != nil {
+		return closed, created, carried, err
+	}
+	return rolloverQuery(ctx, tx, closed, at, promQL)
+}
+
+// rolloverQuery ends the validity of the given query at the given time and creates a new version with the given PromQL starting at that time.
+// See RolloverQuery for details.
+func rolloverQuery(ctx context.Context, tx *sqlx.Tx, closed Query, at time.Time, promQL string) (_ Query, created Query, carried []Query, err error) {
+	var subQueries []Query
+	if err := sqlx.SelectContext(ctx, tx, &subQueries,
+		"SELECT * FROM queries WHERE parent_id = $1 AND upper(during) > $2::timestamptz ORDER BY name, lower(during) FOR UPDATE",
+		closed.Id, at); err != nil {
+		return closed, created, carried, fmt.Errorf("failed to load sub-queries: %w", err)
+	}
+
+	next := closed
+	next.Query = promQL
+	closed.During, next.During = splitTimerange(closed.During, at)
+
+	if closed, err = UpdateQuery(ctx, tx, closed); err != nil {
+		return closed, created, carried, fmt.Errorf("failed to close query: %w", err)
+	}
+	if created, err = CreateQuery(tx, next); err != nil {
+		return closed, created, carried, fmt.Errorf("failed to create query: %w", err)
+	}
+
+	for _, sub := range subQueries {
+		if sub.During.Lower.Status == pgtype.Present && !sub.During.Lower.Time.Before(at) {
+			sub.ParentID = sql.NullString{String: created.Id, Valid: true}
+			if sub, err = UpdateQuery(ctx, tx, sub); err != nil {
+				return closed, created, carried, fmt.Errorf("failed to move sub-query %q: %w", sub.Name, err)
+			}
+			carried = append(carried, sub)
+			continue
+		}
+
+		nextSub := sub
+		sub.During, nextSub.During = splitTimerange(sub.During, at)
+		nextSub.ParentID = sql.NullString{String: created.Id, Valid: true}
+		if _, err := UpdateQuery(ctx, tx, sub); err != nil {
+			return closed, created, carried, fmt.Errorf("failed to close sub-query %q: %w", sub.Name, err)
+		}
+		if nextSub, err = CreateQuery(tx, nextSub); err != nil {
+			return closed, created, carried, fmt.Errorf("failed to create sub-query %q: %w", sub.Name, err)
+		}
+		carried = append(carried, nextSub)
+	}
+	return closed, created, carried, nil
+}
@@ -0,0 +1,99 @@
+package db_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/appuio/appuio-cloud-reporting/pkg/db"
+	"github.com/appuio/appuio-cloud-reporting/pkg/db/dbtest"
+)
+
+type QueriesTestSuite struct {
+	dbtest.Suite
+}
+
+func (s *QueriesTestSuite) TestQueries_Rollover() {
+	t := s.T()
+	ctx := context.Background()
+	tx := s.Begin()
+	defer tx.Rollback()
+
+	at := time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC)
+	later := at.Add(24 * time.Hour)
+
+	parent, err := db.CreateQuery(tx, db.Query{Name: "test_rollover", Query: "sum(up)", Unit: "MiB", During: db.InfiniteRange()})
+	require.NoError(t, err)
+	parentID := sql.NullString{String: parent.Id, Valid: true}
+	_, err = db.CreateQuery(tx, db.Query{ParentID: parentID, Name: "test_rollover_sub", Query: "sum(up)", Unit: "MiB", During: db.InfiniteRange()})
+	require.NoError(t, err)
+	_, err = db.CreateQuery(tx, db.Query{ParentID: parentID, Name: "test_rollover_later", Query: "sum(up)", Unit: "MiB",
+		During: db.Timerange(db.MustTimestamp(later), db.MustTimestamp(later.Add(time.Hour)))})
+	require.NoError(t, err)
+	_, err = db.CreateQuery(tx, db.Query{ParentID: parentID, Name: "test_rollover_ended", Query: "sum(up)", Unit: "MiB",
+		During: db.Timerange(db.MustTimestamp(at.Add(-time.Hour)), db.MustTimestamp(at))})
+	require.NoError(t, err)
+
+	closed, created, carried, err := db.RolloverQuery(ctx, tx, "test_rollover", at, "sum(up) * 2")
+	require.NoError(t, err)
+	require.Equal(t, "[-infinity,2022-01-01T00:00:00Z)", db.FormatTimerange(closed.During))
+	require.Equal(t, "[2022-01-01T00:00:00Z,infinity)", db.FormatTimerange(created.During))
+	require.Equal(t, "sum(up)", closed.Query)
+	require.Equal(t, "sum(up) * 2", created.Query)
+	require.Equal(t, "MiB", created.Unit)
+	require.Len(t, carried, 2)
+
+	current, err := db.GetQueryAt(ctx, tx, "test_rollover", later)
+	require.NoError(t, err)
+	require.Equal(t, created.Id, current.Id)
+	subQueries, err := db.ListSubQueries(ctx, tx, created.Id, nil)
+	require.NoError(t, err)
+	require.Len(t, subQueries, 2)
+	oldSubQueries, err := db.ListSubQueries(ctx, tx, closed.Id, nil)
+	require.NoError(t, err)
+	require.Len(t, oldSubQueries, 2)
+	require.Equal(t, "test_rollover_ended", oldSubQueries[0].Name)
+	require.Equal(t, "[-infinity,2022-01-01T00:00:00Z)", db.FormatTimerange(oldSubQueries[1].During))
+
+	_, _, _, err = db.RolloverQuery(ctx, tx, "test_rollover", at, "sum(up) * 3")
+	require.Error(t, err, "rolling over a query at its lower bound should fail")
+	_, _, _, err = db.RolloverQuery(ctx, tx, "test_rollover", later, "sum(up")
+	require.ErrorIs(t, err, db.ErrInvalidQuery)
+}
+
+func (s *QueriesTestSuite) TestQueries_RolloverInvalidStoredQuery() {
+	t := s.T()
+	ctx := context.Background()
+	tx := s.Begin()
+	defer tx.Rollback()
+
+	at := time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	// Queries stored before PromQL was validated might not parse
+	var broken db.Query
+	require.NoError(t, db.GetNamedContext(ctx, tx, &broken,
+		"INSERT INTO queries (name, query, unit, during) VALUES (:name, :query, :unit, :during) RETURNING *",
+		db.Query{Name: "test_rollover_broken", Query: "sum(up", Unit: "MiB", During: db.InfiniteRange()}))
+	_, err := db.CreateQuery(tx, db.Query{ParentID: sql.NullString{String: broken.Id, Valid: true},
+		Name: "test_rollover_broken_sub", Query: "sum(up)", Unit: "MiB", During: db.InfiniteRange()})
+	require.NoError(t, err)
+
+	closed, created, carried, err := db.RolloverQuery(ctx, tx, "test_rollover_broken", at, "sum(up)")
+	require.NoError(t, err)
+	require.Equal(t, "sum(up", closed.Query)
+	require.Equal(t, "[-infinity,2022-01-01T00:00:00Z)", db.FormatTimerange(closed.During))
+	require.Equal(t, "sum(up)", created.Query)
+	require.Len(t, carried, 1)
+
+	closed.Query = "sum(down"
+	_, err = db.UpdateQuery(ctx, tx, closed)
+	require.ErrorIs(t, err, db.ErrInvalidQuery, "changed PromQL should still be validated")
+}
+
+func TestQueries(t *testing.T) {
+	suite.Run(t, new(QueriesTestSuite))
+}
@@ -0,0 +1,59 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ListQueryParameters returns all query parameters ordered by name and start of their validity.
+// If at is not nil, only parameters valid at the given time are returned.
+func ListQueryParameters(ctx context.Context, q sqlx.QueryerContext, at *time.Time) ([]QueryParameter, error) {
+	var params []QueryParameter
+	err := sqlx.SelectContext(ctx, q, &params,
+		`SELECT * FROM query_parameters
+			WHERE $1::timestamptz IS NULL OR during @> $1::timestamptz
+			ORDER BY name, lower(during)`,
+		at)
+	return params, err
+}
+
+// QueryParametersAt returns the values of all query parameters valid at the given time by their name.
+func QueryParametersAt(ctx context.Context, q sqlx.QueryerContext, at time.Time) (map[string]string, error) {
+	params, err := ListQueryParameters(ctx, q, &at)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]string, len(params))
+	for _, p := range params {
+		values[p.Name] = p.Value
+	}
+	return values, nil
+}
+
+// UpdateQueryParameter updates all fields of the query parameter with the id of the given parameter.
+func UpdateQueryParameter(ctx context.Context, p NamedPreparerContext, in QueryParameter) (QueryParameter, error) {
+	var param QueryParameter
+	err := GetNamedContext(ctx, p, &param,
+		"UPDATE query_parameters SET name = :name, value = :value, during = :during WHERE id = :id RETURNING *", in)
+	if errors.Is(err, sql.ErrNoRows) {
+		return param, fmt.Errorf("no query parameter with id %q found", in.Id)
+	}
+	return param, translateError(err)
+}
+
+// DeleteQueryParameter deletes the query parameter with the given id.
+func DeleteQueryParameter(ctx context.Context, e sqlx.ExecerContext, id string) error {
+	res, err := e.ExecContext(ctx, "DELETE FROM query_parameters WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("no query parameter with id %q found", id)
+	}
+	return nil
+}
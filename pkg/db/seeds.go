@@ -1,11 +1,15 @@
 package db
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"time"
 
 	_ "embed"
 
+	"github.com/jackc/pgtype"
 	"github.com/jmoiron/sqlx"
 )
 
@@ -59,44 +63,125 @@ var DefaultQueries = []Query{
 	},
 }
 
+// SeedOptions configures how default queries are seeded.
+type SeedOptions struct {
+	// At is the timestamp at which changed default queries are rolled over to their new version.
+	// Existing queries are compared to the version valid at this timestamp.
+	// Defaults to the current time.
+	At time.Time
+	// DryRun computes the changes without committing them.
+	DryRun bool
+	// Repair links existing sub-queries without parent to the parent query they are defined for by name.
+	// Sub-queries are linked before changed parents are rolled over, so they are carried over to the new versions.
+	Repair bool
+}
+
+const (
+	// SeedActionCreate is reported for default queries that did not exist yet.
+	SeedActionCreate = "create"
+	// SeedActionRollover is reported for default queries whose current version ended and a new version was created.
+	SeedActionRollover = "rollover"
+	// SeedActionUpdate is reported for default queries whose version starting at the rollover timestamp was updated in place.
+	SeedActionUpdate = "update"
+	// SeedActionRelink is reported for orphaned default sub-queries linked to their parent query.
+	SeedActionRelink = "relink"
+	// SeedActionSkip is reported for default queries which can't be seeded. The reason is given in SeedChange.Reason.
+	SeedActionSkip = "skip"
+)
+
+// SeedChange describes a change to a default query done by seeding.
+type SeedChange struct {
+	Name   string
+	Action string
+	// Old is the PromQL of the replaced version. Empty for created queries.
+	Old string
+	// New is the embedded PromQL of the query.
+	New string
+	// Parent is the name of the parent query a sub-query was created under or linked to.
+	Parent string
+	// Reason describes why a query was skipped.
+	Reason string
+}
+
 // Seed seeds the database with "starter" data.
 // Is idempotent and thus can be executed multiple times in one database.
-func Seed(db *sql.DB) error {
-	return SeedQueries(db, DefaultQueries)
+// Existing default queries that differ from the embedded ones are rolled over to a new version.
+// Returns the changed queries.
+func Seed(db *sql.DB, opts SeedOptions) ([]SeedChange, error) {
+	return SeedQueries(db, DefaultQueries, opts)
 }
 
-func SeedQueries(db *sql.DB, queries []Query) error {
+// SeedQueries seeds the given queries in one transaction.
+// See Seed for details.
+func SeedQueries(db *sql.DB, queries []Query, opts SeedOptions) ([]SeedChange, error) {
+	if opts.At.IsZero() {
+		opts.At = time.Now()
+	}
+
 	dbx := NewDBx(db)
 	tx, err := dbx.Beginx()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer tx.Rollback()
 
-	if err := createQueries(tx, queries); err != nil {
-		return err
+	var changes []SeedChange
+	if opts.Repair {
+		relinked, err := relinkSubQueries(tx, queries)
+		changes = append(changes, relinked...)
+		if err != nil {
+			return changes, err
+		}
+	}
+	created, err := createQueries(tx, queries, opts.At)
+	changes = append(changes, created...)
+	if err != nil {
+		return changes, err
 	}
 
-	return tx.Commit()
+	if opts.DryRun {
+		return changes, nil
+	}
+	return changes, tx.Commit()
 }
 
-func createQueries(tx *sqlx.Tx, queries []Query) error {
+func createQueries(tx *sqlx.Tx, queries []Query, at time.Time) ([]SeedChange, error) {
+	var changes []SeedChange
 	for _, q := range queries {
 		exists, err := queryExistsByName(tx, q.Name)
 		if err != nil {
-			return fmt.Errorf("error checking if query exists: %w", err)
+			return changes, fmt.Errorf("error checking if query exists: %w", err)
 		}
 		if exists {
-			fmt.Printf("Found query with name '%s'. Skip creating default query.\n", q.Name)
+			change, err := updateQuery(tx, q, at)
+			if err != nil {
+				return changes, fmt.Errorf("error updating default query: %w", err)
+			}
+			if change != nil {
+				changes = append(changes, *change)
+			}
+			for _, subQuery := range q.subQueries {
+				change, err := updateSubQuery(tx, q.Name, subQuery, at)
+				if err != nil {
+					return changes, fmt.Errorf("error updating default sub-query: %w", err)
+				}
+				if change != nil {
+					changes = append(changes, *change)
+				}
+			}
 			continue
 		}
 
+		if err := validateQuery(q); err != nil {
+			return changes, err
+		}
 		err = GetNamed(tx, &q.Id,
 			"INSERT INTO queries (name,description,query,unit,during) VALUES (:name,:description,:query,:unit,'[-infinity,infinity)') RETURNING id",
 			q)
 		if err != nil {
-			return fmt.Errorf("error creating default query: %w", err)
+			return changes, fmt.Errorf("error creating default query: %w", err)
 		}
+		changes = append(changes, SeedChange{Name: q.Name, Action: SeedActionCreate, New: q.Query})
 
 		for _, subQuery := range q.subQueries {
 			subQuery.ParentID = sql.NullString{
@@ -105,19 +190,147 @@ func createQueries(tx *sqlx.Tx, queries []Query) error {
 			}
 			exists, err := queryExistsByName(tx, subQuery.Name)
 			if err != nil {
-				return fmt.Errorf("error checking if sub-query exists: %w", err)
+				return changes, fmt.Errorf("error checking if sub-query exists: %w", err)
 			}
 			if exists {
-				fmt.Printf("Found sub-query with name '%s'. Skip creating default query.\n", subQuery.Name)
+				changes = append(changes, SeedChange{Name: subQuery.Name, Action: SeedActionSkip, Parent: q.Name,
+					Reason: "a query with the same name but another parent exists"})
 				continue
 			}
-			_, err = tx.NamedExec("INSERT INTO queries (name,description,query,unit,during) VALUES (:name,:description,:query,:unit,'[-infinity,infinity)')", subQuery)
+			if err := validateQuery(subQuery); err != nil {
+				return changes, err
+			}
+			_, err = tx.NamedExec("INSERT INTO queries (name,description,query,unit,during,parent_id) VALUES (:name,:description,:query,:unit,'[-infinity,infinity)',:parent_id)", subQuery)
 			if err != nil {
-				return fmt.Errorf("error creating default sub-query: %w", err)
+				return changes, fmt.Errorf("error creating default sub-query: %w", err)
 			}
+			changes = append(changes, SeedChange{Name: subQuery.Name, Action: SeedActionCreate, New: subQuery.Query, Parent: q.Name})
+		}
+	}
+	return changes, nil
+}
+
+// updateQuery rolls over the existing query with the name of the given default query if its version valid at the given time differs.
+// A version starting at the given time is updated in place.
+// Returns nil if nothing changed.
+func updateQuery(tx *sqlx.Tx, q Query, at time.Time) (*SeedChange, error) {
+	ctx := context.Background()
+	var current Query
+	err := sqlx.GetContext(ctx, tx, &current, "SELECT * FROM queries WHERE name = $1 AND during @> $2::timestamptz FOR UPDATE", q.Name, at)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &SeedChange{Name: q.Name, Action: SeedActionSkip, Reason: "no version valid at " + at.Format(time.RFC3339)}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if current.Query == q.Query {
+		return nil, nil
+	}
+
+	change := &SeedChange{Name: q.Name, Action: SeedActionRollover, Old: current.Query, New: q.Query}
+	if current.During.Lower.Status == pgtype.Present && current.During.Lower.Time.Equal(at) {
+		change.Action = SeedActionUpdate
+		current.Query = q.Query
+		_, err := UpdateQuery(ctx, tx, current)
+		return change, err
+	}
+	_, _, _, err = rolloverQuery(ctx, tx, current, at, q.Query)
+	return change, err
+}
+
+// updateSubQuery updates the existing default sub-query like updateQuery.
+// A missing sub-query is created under the version of its parent valid at the given time, starting at that time.
+// Returns nil if nothing changed.
+func updateSubQuery(tx *sqlx.Tx, parentName string, q Query, at time.Time) (*SeedChange, error) {
+	ctx := context.Background()
+	var parent Query
+	err := sqlx.GetContext(ctx, tx, &parent, "SELECT * FROM queries WHERE name = $1 AND parent_id IS NULL AND during @> $2::timestamptz", parentName, at)
+	if errors.Is(err, sql.ErrNoRows) {
+		// The parent is skipped as well, its sub-queries would never run.
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var existing Query
+	err = sqlx.GetContext(ctx, tx, &existing, "SELECT * FROM queries WHERE name = $1 AND during @> $2::timestamptz", q.Name, at)
+	if err == nil {
+		if existing.ParentID.String != parent.Id {
+			return &SeedChange{Name: q.Name, Action: SeedActionSkip, Parent: parentName,
+				Reason: "the version valid at " + at.Format(time.RFC3339) + " is not linked to the parent, seed with repair to link it"}, nil
+		}
+		return updateQuery(tx, q, at)
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	q.ParentID = sql.NullString{String: parent.Id, Valid: true}
+	q.During = parent.During
+	if parent.During.Lower.InfinityModifier != pgtype.None || parent.During.Lower.Time.Before(at) {
+		_, q.During = splitTimerange(parent.During, at)
+	}
+	if _, err := CreateQuery(tx, q); err != nil {
+		return nil, fmt.Errorf("error creating default sub-query %q: %w", q.Name, err)
+	}
+	return &SeedChange{Name: q.Name, Action: SeedActionCreate, New: q.Query, Parent: parentName}, nil
+}
+
+// relinkSubQueries links the sub-queries of the given queries without parent to the versions of their parent.
+// An orphaned sub-query overlapping multiple versions of its parent is split at the start of every later version,
+// so that every version gets its own copy of the sub-query.
+// Sub-queries seeded by earlier versions were created without parent and were never run.
+func relinkSubQueries(tx *sqlx.Tx, queries []Query) ([]SeedChange, error) {
+	ctx := context.Background()
+	var changes []SeedChange
+	for _, q := range queries {
+		for _, subQuery := range q.subQueries {
+			var orphans []Query
+			if err := sqlx.SelectContext(ctx, tx, &orphans,
+				"SELECT * FROM queries WHERE name = $1 AND parent_id IS NULL ORDER BY lower(during) FOR UPDATE", subQuery.Name,
+			); err != nil {
+				return changes, fmt.Errorf("error loading orphaned sub-query %q: %w", subQuery.Name, err)
+			}
+			for _, orphan := range orphans {
+				relinked, err := relinkSubQuery(ctx, tx, q.Name, orphan)
+				if err != nil {
+					return changes, fmt.Errorf("error relinking sub-query %q: %w", subQuery.Name, err)
+				}
+				for i := 0; i < relinked; i++ {
+					changes = append(changes, SeedChange{Name: subQuery.Name, Action: SeedActionRelink, Parent: q.Name})
+				}
+			}
+		}
+	}
+	return changes, nil
+}
+
+// relinkSubQuery links the orphaned sub-query to every version of the parent with the given name it overlaps.
+// Returns the number of versions the sub-query was linked to.
+func relinkSubQuery(ctx context.Context, tx *sqlx.Tx, parentName string, orphan Query) (int, error) {
+	var parents []Query
+	if err := sqlx.SelectContext(ctx, tx, &parents,
+		"SELECT * FROM queries WHERE name = $1 AND parent_id IS NULL AND during && $2::tstzrange ORDER BY lower(during)",
+		parentName, orphan.During,
+	); err != nil {
+		return 0, err
+	}
+	for i, parent := range parents {
+		linked := orphan
+		if i+1 < len(parents) {
+			// The versions of the parent don't overlap, so the next version starts within the orphan.
+			linked.During, orphan.During = splitTimerange(orphan.During, parents[i+1].During.Lower.Time)
+		}
+		linked.ParentID = sql.NullString{String: parent.Id, Valid: true}
+		if i == 0 {
+			if _, err := UpdateQuery(ctx, tx, linked); err != nil {
+				return i, err
+			}
+			continue
+		}
+		if _, err := CreateQuery(tx, linked); err != nil {
+			return i, err
 		}
 	}
-	return nil
+	return len(parents), nil
 }
 
 func queryExistsByName(tx *sqlx.Tx, name string) (bool, error) {
@@ -2,6 +2,7 @@ package db_test
 
 import (
 	"testing"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/stretchr/testify/require"
@@ -27,32 +28,127 @@ func (s *SeedsTestSuite) TestSeedDefaultQueries() {
 	count := "SELECT COUNT(*) FROM queries"
 	requireQueryEqual(t, d, 0, count)
 
-	require.NoError(t, db.SeedQueries(d.DB, []db.Query{
+	_, err = db.SeedQueries(d.DB, []db.Query{
 		{
 			Name:        "appuio_cloud_memory",
 			Description: "Memory usage (maximum of requested and used memory) aggregated by namespace",
+			Query:       "sum(memory)",
 			Unit:        "MiB",
 		},
-	}))
+	}, db.SeedOptions{})
+	require.NoError(t, err)
 	t.Log(t, count)
 	requireQueryEqual(t, d, 1, count)
 
-	// Some appuio_cloud_memory exists so don't create sub queries
-	err = db.Seed(d.DB)
+	at := time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC)
+	changes, err := db.Seed(d.DB, db.SeedOptions{At: at, DryRun: true})
+	require.NoError(t, err)
+	require.Len(t, changes, 5)
+	require.Equal(t, db.SeedChange{Name: "appuio_cloud_memory", Action: db.SeedActionRollover, Old: "sum(memory)", New: db.DefaultQueries[0].Query}, changes[0])
+	requireQueryEqual(t, d, 1, count)
+
+	// Some appuio_cloud_memory exists so roll it over and create the missing sub queries under the new version
+	changes, err = db.Seed(d.DB, db.SeedOptions{At: at})
+	require.NoError(t, err)
+	require.Len(t, changes, 5)
+	require.Equal(t, db.SeedActionCreate, changes[1].Action)
+	require.Equal(t, "appuio_cloud_memory", changes[1].Parent)
+	requireQueryEqual(t, d, expQueryNum+1, count)
+	requireQueryTrue(t, d, "SELECT during = tstzrange($1, 'infinity') FROM queries WHERE name = 'appuio_cloud_memory' AND query != 'sum(memory)'", at)
+	requireQueryEqual(t, d, 2, `SELECT COUNT(*) FROM queries sub JOIN queries p ON sub.parent_id = p.id
+		WHERE p.name = 'appuio_cloud_memory' AND p.query != 'sum(memory)' AND sub.during = p.during`)
+	changes, err = db.Seed(d.DB, db.SeedOptions{At: at})
 	require.NoError(t, err)
-	requireQueryEqual(t, d, expQueryNum-2, count)
+	require.Empty(t, changes)
 
 	// Drop queries and check we create sub queries
 	_, err = d.DB.Exec("DELETE FROM queries;")
 	require.NoError(t, err)
-	err = db.Seed(d.DB)
+	_, err = db.Seed(d.DB, db.SeedOptions{})
 	require.NoError(t, err)
 	requireQueryEqual(t, d, expQueryNum, count)
-	err = db.Seed(d.DB)
+	requireQueryEqual(t, d, 2, "SELECT COUNT(*) FROM queries sub JOIN queries p ON sub.parent_id = p.id WHERE p.name = 'appuio_cloud_memory'")
+	changes, err = db.Seed(d.DB, db.SeedOptions{})
 	require.NoError(t, err)
+	require.Empty(t, changes)
 	requireQueryEqual(t, d, expQueryNum, count)
 }
 
+func (s *SeedsTestSuite) TestSeedRepairOrphanedSubQueries() {
+	t := s.T()
+	d := s.DB()
+
+	_, err := d.Exec("DELETE FROM queries")
+	require.NoError(t, err)
+	_, err = db.Seed(d.DB, db.SeedOptions{})
+	require.NoError(t, err)
+	// Sub-queries seeded by earlier versions had no parent
+	_, err = d.Exec("UPDATE queries SET parent_id = NULL")
+	require.NoError(t, err)
+
+	linked := "SELECT COUNT(*) FROM queries sub JOIN queries p ON sub.parent_id = p.id WHERE p.name = 'appuio_cloud_memory'"
+	changes, err := db.Seed(d.DB, db.SeedOptions{Repair: true, DryRun: true})
+	require.NoError(t, err)
+	require.Len(t, changes, 2)
+	requireQueryEqual(t, d, 0, linked)
+
+	changes, err = db.Seed(d.DB, db.SeedOptions{Repair: true})
+	require.NoError(t, err)
+	require.Len(t, changes, 2)
+	require.Equal(t, db.SeedActionRelink, changes[0].Action)
+	require.Equal(t, "appuio_cloud_memory", changes[0].Parent)
+	requireQueryEqual(t, d, 2, linked)
+
+	changes, err = db.Seed(d.DB, db.SeedOptions{Repair: true})
+	require.NoError(t, err)
+	require.Empty(t, changes)
+}
+
+func (s *SeedsTestSuite) TestSeedRepairOrphanedSubQueriesWithChangedParent() {
+	t := s.T()
+	d := s.DB()
+
+	_, err := d.Exec("DELETE FROM queries")
+	require.NoError(t, err)
+	_, err = db.Seed(d.DB, db.SeedOptions{})
+	require.NoError(t, err)
+	_, err = d.Exec("UPDATE queries SET parent_id = NULL")
+	require.NoError(t, err)
+	// The parent query changed since the sub-queries were seeded
+	_, err = d.Exec("UPDATE queries SET query = 'sum(memory)' WHERE name = 'appuio_cloud_memory'")
+	require.NoError(t, err)
+
+	at := time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC)
+	changes, err := db.Seed(d.DB, db.SeedOptions{At: at, Repair: true})
+	require.NoError(t, err)
+	require.Len(t, changes, 3)
+	require.Equal(t, db.SeedActionRelink, changes[0].Action)
+	require.Equal(t, db.SeedActionRelink, changes[1].Action)
+	require.Equal(t, db.SeedActionRollover, changes[2].Action)
+
+	requireQueryEqual(t, d, 0, "SELECT COUNT(*) FROM queries WHERE parent_id IS NULL AND name LIKE 'appuio_cloud_memory_subquery_%'")
+	perVersion := `SELECT COUNT(*) FROM queries sub JOIN queries p ON sub.parent_id = p.id
+		WHERE p.name = 'appuio_cloud_memory' AND p.query = $1 AND sub.during = p.during`
+	requireQueryEqual(t, d, 2, perVersion, "sum(memory)")
+	requireQueryEqual(t, d, 2, perVersion, db.DefaultQueries[0].Query)
+
+	// Orphans overlapping multiple versions of the parent are split per version
+	_, err = d.Exec("DELETE FROM queries WHERE parent_id IS NOT NULL AND lower(during) = $1", at)
+	require.NoError(t, err)
+	_, err = d.Exec("UPDATE queries SET parent_id = NULL, during = tstzrange('-infinity', 'infinity') WHERE parent_id IS NOT NULL")
+	require.NoError(t, err)
+
+	changes, err = db.Seed(d.DB, db.SeedOptions{At: at, Repair: true})
+	require.NoError(t, err)
+	require.Len(t, changes, 4)
+	requireQueryEqual(t, d, 2, perVersion, "sum(memory)")
+	requireQueryEqual(t, d, 2, perVersion, db.DefaultQueries[0].Query)
+
+	changes, err = db.Seed(d.DB, db.SeedOptions{At: at, Repair: true})
+	require.NoError(t, err)
+	require.Empty(t, changes)
+}
+
 func requireQueryEqual[T any](t *testing.T, q sqlx.Queryer, expected T, query string, args ...interface{}) {
 	t.Helper()
 	var res T
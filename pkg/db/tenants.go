@@ -0,0 +1,32 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ListTenants returns all tenants ordered by their source.
+func ListTenants(ctx context.Context, q sqlx.QueryerContext) ([]Tenant, error) {
+	var tenants []Tenant
+	err := sqlx.SelectContext(ctx, q, &tenants, "SELECT * FROM tenants ORDER BY source")
+	return tenants, err
+}
+
+// SetTenantBillingCurrency sets the billing currency of the tenant with the given source.
+// Returns the updated tenant.
+func SetTenantBillingCurrency(ctx context.Context, q sqlx.QueryerContext, source, currency string) (Tenant, error) {
+	if err := ValidateCurrency(currency); err != nil {
+		return Tenant{}, err
+	}
+	var tenant Tenant
+	err := sqlx.GetContext(ctx, q, &tenant,
+		"UPDATE tenants SET billing_currency = $2 WHERE source = $1 RETURNING *", source, currency)
+	if errors.Is(err, sql.ErrNoRows) {
+		return tenant, fmt.Errorf("no tenant with source %q found", source)
+	}
+	return tenant, err
+}
@@ -0,0 +1,50 @@
+package db_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/appuio/appuio-cloud-reporting/pkg/db"
+	"github.com/appuio/appuio-cloud-reporting/pkg/db/dbtest"
+)
+
+type TenantsTestSuite struct {
+	dbtest.Suite
+}
+
+func (s *TenantsTestSuite) TestTenants_SetBillingCurrency() {
+	t := s.T()
+	ctx := context.Background()
+	tx := s.Begin()
+	defer tx.Rollback()
+
+	_, err := tx.Exec("INSERT INTO tenants (source) VALUES ('test-tenant')")
+	require.NoError(t, err)
+
+	tenants, err := db.ListTenants(ctx, tx)
+	require.NoError(t, err)
+	var found bool
+	for _, tenant := range tenants {
+		if tenant.Source == "test-tenant" {
+			found = true
+			require.Equal(t, db.DefaultCurrency, tenant.BillingCurrency)
+		}
+	}
+	require.True(t, found)
+
+	tenant, err := db.SetTenantBillingCurrency(ctx, tx, "test-tenant", "EUR")
+	require.NoError(t, err)
+	require.Equal(t, "EUR", tenant.BillingCurrency)
+
+	_, err = db.SetTenantBillingCurrency(ctx, tx, "test-tenant", "euro")
+	require.Error(t, err)
+	_, err = db.SetTenantBillingCurrency(ctx, tx, "missing-tenant", "EUR")
+	require.Error(t, err)
+}
+
+func TestTenants(t *testing.T) {
+	suite.Run(t, new(TenantsTestSuite))
+}
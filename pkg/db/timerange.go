@@ -0,0 +1,83 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgtype"
+)
+
+const timerangeSeparator = ","
+
+// ParseTimerange parses a timerange in the form of "from,until" or "[from,until)" with [from,until) bounds.
+// Both timestamps are in the form of RFC3339. An empty or "-infinity" lower and an empty or "infinity" upper bound are unbounded.
+func ParseTimerange(raw string) (pgtype.Tstzrange, error) {
+	trimmed := strings.TrimSpace(raw)
+	if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, ")") {
+		trimmed = trimmed[1 : len(trimmed)-1]
+	}
+	parts := strings.Split(trimmed, timerangeSeparator)
+	if len(parts) != 2 {
+		return pgtype.Tstzrange{}, fmt.Errorf("expected timerange in the form of `from%suntil` got %q", timerangeSeparator, raw)
+	}
+
+	lower, err := parseTimerangeBound(parts[0], "-infinity", pgtype.NegativeInfinity)
+	if err != nil {
+		return pgtype.Tstzrange{}, fmt.Errorf("invalid lower bound: %w", err)
+	}
+	upper, err := parseTimerangeBound(parts[1], "infinity", pgtype.Infinity)
+	if err != nil {
+		return pgtype.Tstzrange{}, fmt.Errorf("invalid upper bound: %w", err)
+	}
+	if lower.InfinityModifier == pgtype.None && upper.InfinityModifier == pgtype.None && !lower.Time.Before(upper.Time) {
+		return pgtype.Tstzrange{}, fmt.Errorf("lower bound %s must be before upper bound %s", parts[0], parts[1])
+	}
+
+	return Timerange(lower, upper), nil
+}
+
+func parseTimerangeBound(raw string, infinity string, modifier pgtype.InfinityModifier) (pgtype.Timestamptz, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" || raw == infinity {
+		return MustTimestamp(modifier), nil
+	}
+	ts, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return pgtype.Timestamptz{}, err
+	}
+	return Timestamp(ts.In(time.UTC))
+}
+
+// FormatTimerange formats the given timerange in the form of "[from,until)".
+// Timestamps are formatted as RFC3339 in UTC.
+func FormatTimerange(r pgtype.Tstzrange) string {
+	lowerBound, upperBound := "(", ")"
+	if r.LowerType == pgtype.Inclusive {
+		lowerBound = "["
+	}
+	if r.UpperType == pgtype.Inclusive {
+		upperBound = "]"
+	}
+	return lowerBound + FormatTimestamp(r.Lower) + timerangeSeparator + FormatTimestamp(r.Upper) + upperBound
+}
+
+// FormatTimestamp formats the given timestamp as RFC3339 in UTC or as "infinity"/"-infinity".
+func FormatTimestamp(ts pgtype.Timestamptz) string {
+	switch ts.InfinityModifier {
+	case pgtype.Infinity:
+		return "infinity"
+	case pgtype.NegativeInfinity:
+		return "-infinity"
+	}
+	if ts.Status != pgtype.Present {
+		return ""
+	}
+	return ts.Time.In(time.UTC).Format(time.RFC3339)
+}
+
+// splitTimerange splits the given timerange at the given timestamp into [lower,at) and [at,upper).
+func splitTimerange(r pgtype.Tstzrange, at time.Time) (pgtype.Tstzrange, pgtype.Tstzrange) {
+	split := MustTimestamp(at.In(time.UTC))
+	return Timerange(r.Lower, split), Timerange(split, r.Upper)
+}
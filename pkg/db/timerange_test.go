@@ -0,0 +1,88 @@
+package db_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jackc/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/appuio/appuio-cloud-reporting/pkg/db"
+)
+
+func TestParseTimerange(t *testing.T) {
+	from := time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC)
+	until := time.Date(2023, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := map[string]struct {
+		raw      string
+		expected pgtype.Tstzrange
+		errf     func(require.TestingT, error, ...interface{})
+	}{
+		"Bounded": {
+			raw:      "2022-01-01T00:00:00Z,2023-01-01T00:00:00Z",
+			expected: db.Timerange(db.MustTimestamp(from), db.MustTimestamp(until)),
+			errf:     require.NoError,
+		},
+		"BoundedOtherTimezone": {
+			raw:      "2022-01-01T01:00:00+01:00,2023-01-01T00:00:00Z",
+			expected: db.Timerange(db.MustTimestamp(from), db.MustTimestamp(until)),
+			errf:     require.NoError,
+		},
+		"Brackets": {
+			raw:      "[2022-01-01T00:00:00Z,2023-01-01T00:00:00Z)",
+			expected: db.Timerange(db.MustTimestamp(from), db.MustTimestamp(until)),
+			errf:     require.NoError,
+		},
+		"Infinite": {
+			raw:      "-infinity,infinity",
+			expected: db.InfiniteRange(),
+			errf:     require.NoError,
+		},
+		"EmptyBounds": {
+			raw:      ",",
+			expected: db.InfiniteRange(),
+			errf:     require.NoError,
+		},
+		"OpenUpperBound": {
+			raw:      "2022-01-01T00:00:00Z,",
+			expected: db.Timerange(db.MustTimestamp(from), db.MustTimestamp(pgtype.Infinity)),
+			errf:     require.NoError,
+		},
+		"MissingSeparator": {
+			raw:  "2022-01-01T00:00:00Z",
+			errf: require.Error,
+		},
+		"InvalidTimestamp": {
+			raw:  "2022-01-01,",
+			errf: require.Error,
+		},
+		"LowerAfterUpper": {
+			raw:  "2023-01-01T00:00:00Z,2022-01-01T00:00:00Z",
+			errf: require.Error,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			r, err := db.ParseTimerange(tc.raw)
+			tc.errf(t, err)
+			if err == nil {
+				assert.Equal(t, tc.expected, r)
+			}
+		})
+	}
+}
+
+func TestFormatTimerange(t *testing.T) {
+	from := time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, "[-infinity,infinity)", db.FormatTimerange(db.InfiniteRange()))
+	assert.Equal(t, "[2022-01-01T00:00:00Z,infinity)", db.FormatTimerange(db.Timerange(db.MustTimestamp(from), db.MustTimestamp(pgtype.Infinity))))
+
+	r := db.Timerange(db.MustTimestamp(from), db.MustTimestamp(pgtype.Infinity))
+	parsed, err := db.ParseTimerange(db.FormatTimerange(r))
+	require.NoError(t, err)
+	assert.Equal(t, r, parsed, "formatted timerange should be parseable")
+}
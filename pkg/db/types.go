@@ -3,9 +3,11 @@ package db
 import (
 	"database/sql"
 	"fmt"
+	"regexp"
 	"time"
 
 	"github.com/jackc/pgtype"
+	"github.com/jmoiron/sqlx/types"
 )
 
 type Query struct {
@@ -19,15 +21,116 @@ type Query struct {
 
 	During pgtype.Tstzrange
 
+	// Mode is either QueryModeInstant or QueryModeRange.
+	// Instant queries are evaluated once at the end of the hour and must return a vector.
+	// Range queries are evaluated over the hour every RangeStepSeconds and the returned series are aggregated with RangeAggregation.
+	Mode             string
+	RangeStepSeconds int    `db:"range_step_seconds"`
+	RangeAggregation string `db:"range_aggregation"`
+
+	QueryLabels
+
 	subQueries []Query
 }
 
+// QueryLabels maps the labels of the samples returned by a query to the source key and category of a fact.
+//
+// By default the source key is parsed from the product label in the form of "query:zone:tenant:namespace:class".
+// If any of ZoneLabel, TenantLabel or NamespaceLabel are set, the source key is built from the labels directly.
+// In that case the product label only contains the query element of the source key and defaults to the name of the query if the sample has no product label.
+type QueryLabels struct {
+	// CategoryLabel is the label holding the category in the form of "zone:namespace".
+	// If the sample has no such label and the source key is built from the labels directly, the category is built from the zone and namespace.
+	CategoryLabel string `db:"category_label"`
+	ProductLabel  string `db:"product_label"`
+
+	ZoneLabel      string `db:"zone_label"`
+	TenantLabel    string `db:"tenant_label"`
+	NamespaceLabel string `db:"namespace_label"`
+	// ClassLabel is optional. A missing label results in a source key without class.
+	ClassLabel string `db:"class_label"`
+}
+
+// Direct returns true if the source key is built from the zone, tenant and namespace labels instead of parsed from the product label.
+func (l QueryLabels) Direct() bool {
+	return l.ZoneLabel != "" || l.TenantLabel != "" || l.NamespaceLabel != ""
+}
+
+const (
+	// QueryModeInstant evaluates the query once at the end of the hour.
+	QueryModeInstant = "instant"
+	// QueryModeRange evaluates the query over the hour and aggregates the samples of every series.
+	QueryModeRange = "range"
+)
+
+const (
+	// RangeAggregationAvg takes the average of the samples of a series.
+	RangeAggregationAvg = "avg"
+	// RangeAggregationMax takes the maximum of the samples of a series.
+	RangeAggregationMax = "max"
+	// RangeAggregationSum takes the sum of the samples of a series.
+	RangeAggregationSum = "sum"
+)
+
+// RangeStep returns the resolution of range queries.
+func (q Query) RangeStep() time.Duration {
+	return time.Duration(q.RangeStepSeconds) * time.Second
+}
+
+// WithDefaults sets the mode, range options and category and product labels to their defaults if they are empty.
+func (q Query) WithDefaults() Query {
+	if q.Mode == "" {
+		q.Mode = QueryModeInstant
+	}
+	if q.RangeStepSeconds == 0 {
+		q.RangeStepSeconds = 60
+	}
+	if q.RangeAggregation == "" {
+		q.RangeAggregation = RangeAggregationAvg
+	}
+	if q.CategoryLabel == "" {
+		q.CategoryLabel = "category"
+	}
+	if q.ProductLabel == "" {
+		q.ProductLabel = "product"
+	}
+	return q
+}
+
 // CreateQuery creates the given query
+// Returns ErrInvalidQuery if the PromQL of the query can't be parsed.
 func CreateQuery(p NamedPreparer, in Query) (Query, error) {
 	var query Query
+	if err := validateQuery(in); err != nil {
+		return query, err
+	}
 	err := GetNamed(p, &query,
-		"INSERT INTO queries (name,description,query,unit,during,parent_id) VALUES (:name,:description,:query,:unit,:during,:parent_id) RETURNING *", in)
-	return query, err
+		`INSERT INTO queries
+				(name,description,query,unit,during,parent_id,mode,range_step_seconds,range_aggregation,
+				category_label,product_label,zone_label,tenant_label,namespace_label,class_label)
+			VALUES
+				(:name,:description,:query,:unit,:during,:parent_id,:mode,:range_step_seconds,:range_aggregation,
+				:category_label,:product_label,:zone_label,:tenant_label,:namespace_label,:class_label)
+			RETURNING *`, in.WithDefaults())
+	return query, translateError(err)
+}
+
+// QueryParameter is a variable available to the templates of all queries while it is valid.
+type QueryParameter struct {
+	Id string
+
+	Name  string
+	Value string
+
+	During pgtype.Tstzrange
+}
+
+// CreateQueryParameter creates the given query parameter
+func CreateQueryParameter(p NamedPreparer, in QueryParameter) (QueryParameter, error) {
+	var param QueryParameter
+	err := GetNamed(p, &param,
+		"INSERT INTO query_parameters (name,value,during) VALUES (:name,:value,:during) RETURNING *", in)
+	return param, translateError(err)
 }
 
 type Tenant struct {
@@ -36,6 +139,8 @@ type Tenant struct {
 	// Source is the tenant string read from the 'appuio.io/organization' label.
 	Source string
 	Target sql.NullString
+	// BillingCurrency is the ISO 4217 code of the currency the invoices of the tenant are generated in.
+	BillingCurrency string `db:"billing_currency"`
 }
 
 type Category struct {
@@ -55,16 +160,59 @@ type Product struct {
 	Target sql.NullString
 	Amount float64
 	Unit   string
+	// Currency is the ISO 4217 code of the currency of the amount. Defaults to DefaultCurrency.
+	Currency string
 
 	During pgtype.Tstzrange
 }
 
+// DefaultCurrency is the currency of products and tenants without an explicit currency.
+const DefaultCurrency = "CHF"
+
+var currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// ValidateCurrency checks that the given currency is a three letter ISO 4217 code like "CHF".
+func ValidateCurrency(currency string) error {
+	if !currencyPattern.MatchString(currency) {
+		return fmt.Errorf("invalid currency %q: must be a three letter ISO 4217 code like %q", currency, DefaultCurrency)
+	}
+	return nil
+}
+
+// withDefaults sets the currency to DefaultCurrency if it is empty.
+func (p Product) withDefaults() Product {
+	if p.Currency == "" {
+		p.Currency = DefaultCurrency
+	}
+	return p
+}
+
 // CreateProduct creates the given product
 func CreateProduct(p NamedPreparer, in Product) (Product, error) {
 	var product Product
 	err := GetNamed(p, &product,
-		"INSERT INTO products (source,target,amount,unit,during) VALUES (:source,:target,:amount,:unit,:during) RETURNING *", in)
-	return product, err
+		"INSERT INTO products (source,target,amount,unit,currency,during) VALUES (:source,:target,:amount,:unit,:currency,:during) RETURNING *", in.withDefaults())
+	return product, translateError(err)
+}
+
+// ExchangeRate converts amounts from the source to the target currency while it is valid.
+type ExchangeRate struct {
+	Id string
+
+	SourceCurrency string `db:"source_currency"`
+	TargetCurrency string `db:"target_currency"`
+	// Rate is the amount in the target currency of one unit of the source currency.
+	Rate float64
+
+	During pgtype.Tstzrange
+}
+
+// CreateExchangeRate creates the given exchange rate
+func CreateExchangeRate(p NamedPreparer, in ExchangeRate) (ExchangeRate, error) {
+	var rate ExchangeRate
+	err := GetNamed(p, &rate,
+		"INSERT INTO exchange_rates (source_currency,target_currency,rate,during) VALUES (:source_currency,:target_currency,:rate,:during) RETURNING *", in)
+	return rate, translateError(err)
 }
 
 type Discount struct {
@@ -83,7 +231,7 @@ func CreateDiscount(p NamedPreparer, in Discount) (Discount, error) {
 	var discount Discount
 	err := GetNamed(p, &discount,
 		"INSERT INTO discounts (source,discount,during) VALUES (:source,:discount,:during) RETURNING *", in)
-	return discount, err
+	return discount, translateError(err)
 }
 
 type DateTime struct {
@@ -110,6 +258,39 @@ type Fact struct {
 	Quantity float64
 }
 
+// QuarantinedSample is a sample which could not be resolved to a product or discount when running a report.
+type QuarantinedSample struct {
+	Id string
+
+	QueryId   string `db:"query_id"`
+	Timestamp time.Time
+	// Labels holds the labels of the sample as a JSON object.
+	Labels types.JSONText
+	Value  float64
+	// Reason describes why the sample could not be resolved.
+	Reason string
+}
+
+// ReportRun records a single run of a report for a query and hour.
+type ReportRun struct {
+	Id string
+
+	QueryName string `db:"query_name"`
+	// Timestamp is the start of the reported hour.
+	Timestamp time.Time
+	// Status is either "succeeded" or "failed".
+	Status string
+	// Samples is the number of samples returned by the query and its sub-queries.
+	Samples         int
+	StartedAt       time.Time `db:"started_at"`
+	DurationSeconds float64   `db:"duration_seconds"`
+	// Warnings holds the warnings returned by prometheus.
+	Warnings pgtype.TextArray
+	Error    string
+	// Version is the version of the reporting tool that ran the report.
+	Version string
+}
+
 // BuildDateTime builds a DateTime object from the given timestamp.
 func BuildDateTime(ts time.Time) DateTime {
 	return DateTime{
@@ -151,3 +332,8 @@ func Timerange(lower, upper pgtype.Timestamptz) pgtype.Tstzrange {
 		Status:    pgtype.Present,
 	}
 }
+
+// NullString creates a nullable string which is NULL for the empty string.
+func NullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
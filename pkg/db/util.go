@@ -3,6 +3,7 @@ package db
 import (
 	"context"
 	"fmt"
+	"strings"
 
 	"github.com/jackc/pgtype"
 	"github.com/jmoiron/sqlx"
@@ -78,3 +79,20 @@ func RunInTransaction(ctx context.Context, db *sqlx.DB, cb func(tx *sqlx.Tx) err
 	}
 	return tx.Commit()
 }
+
+// ExpectedReportsQuery builds a query over the reports expected in the period [$1, $2).
+// The query can use the common table expression `expected` holding the name and timestamp of every hour a top-level query was valid in
+// and the given additional common table expressions in the form of "name AS (...)".
+func ExpectedReportsQuery(query string, ctes ...string) string {
+	return `
+	WITH
+		hours AS (
+			SELECT generate_series($1::timestamptz, $2::timestamptz - interval '1 hour', interval '1 hour') AS timestamp
+		),
+		expected AS (
+			SELECT DISTINCT queries.name, hours.timestamp
+				FROM queries
+				INNER JOIN hours ON (queries.during @> hours.timestamp)
+				WHERE queries.parent_id IS NULL
+		)` + strings.Join(append([]string{""}, ctes...), ",\n\t\t") + query
+}
@@ -0,0 +1,120 @@
+package invoice
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EncodeJSON writes the invoices as one JSON document.
+func EncodeJSON(w io.Writer, invoices []Invoice) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "\t")
+	return enc.Encode(invoices)
+}
+
+// EncodeYAML writes the invoices as one YAML document.
+// The keys are the same as the ones of the JSON document.
+func EncodeYAML(w io.Writer, invoices []Invoice) error {
+	// JSON is a subset of YAML, decoding into a node keeps the order and the names of the keys.
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(invoices); err != nil {
+		return err
+	}
+	var node yaml.Node
+	if err := yaml.Unmarshal(buf.Bytes(), &node); err != nil {
+		return err
+	}
+	clearStyle(&node)
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(&node); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// clearStyle resets the flow style the nodes got from being decoded from JSON.
+func clearStyle(n *yaml.Node) {
+	if n.Kind != yaml.ScalarNode {
+		n.Style = 0
+	} else if n.Style == yaml.DoubleQuotedStyle {
+		n.Style = 0
+	}
+	for _, c := range n.Content {
+		clearStyle(c)
+	}
+}
+
+var csvHeader = []string{
+	"tenant_source", "tenant_target",
+	"period_start", "period_end",
+	"category_source", "category_target",
+	"product_source", "product_target",
+	"query_name", "description",
+	"quantity", "quantity_min", "quantity_avg", "quantity_max", "unit",
+	"price_per_unit", "price_currency", "discount", "total", "currency",
+}
+
+// EncodeCSV writes one row per item of the invoices.
+// Every row contains the source and target of the tenant, the category and the product.
+// Sub-items are not included.
+func EncodeCSV(w io.Writer, invoices []Invoice) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, inv := range invoices {
+		for _, cat := range inv.Categories {
+			for _, item := range cat.Items {
+				err := cw.Write([]string{
+					inv.Tenant.Source, inv.Tenant.Target,
+					formatDate(inv.PeriodStart), formatDate(inv.PeriodEnd),
+					cat.Source, cat.Target,
+					item.ProductRef.Source, item.ProductRef.Target,
+					item.QueryName, item.Description,
+					formatFloat(item.Quantity), formatFloat(item.QuantityMin), formatFloat(item.QuantityAvg), formatFloat(item.QuantityMax), item.Unit,
+					formatFloat(item.PricePerUnit), item.PriceCurrency, formatFloat(item.Discount), formatFloat(item.Total), item.Currency,
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// EncodeTable writes a human-readable summary of the invoices with one row per item and the total of every invoice.
+func EncodeTable(w io.Writer, invoices []Invoice) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprint(tw, "Tenant\tCategory\tProduct\tQuantity\tUnit\tPrice\tDiscount\tTotal\tCurrency\n")
+	for _, inv := range invoices {
+		for _, cat := range inv.Categories {
+			for _, item := range cat.Items {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%.2f\t%s\t%g %s\t%g\t%.2f\t%s\n",
+					inv.Tenant.Source, cat.Source, item.ProductRef.Source, item.Quantity, item.Unit, item.PricePerUnit, item.PriceCurrency, item.Discount, item.Total, item.Currency)
+			}
+		}
+		fmt.Fprintf(tw, "%s\tTotal\t\t\t\t\t\t%.2f\t%s\n", inv.Tenant.Source, inv.Total, inv.Currency)
+	}
+	return tw.Flush()
+}
+
+func formatDate(t time.Time) string {
+	return t.Format("2006-01-02")
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
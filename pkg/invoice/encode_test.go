@@ -0,0 +1,86 @@
+package invoice_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+
+	"github.com/appuio/appuio-cloud-reporting/pkg/invoice"
+)
+
+var encodeInvoices = []invoice.Invoice{
+	{
+		Tenant:      invoice.Tenant{Source: "tricell", Target: "98942"},
+		Currency:    "CHF",
+		PeriodStart: time.Date(2022, time.February, 1, 0, 0, 0, 0, time.UTC),
+		PeriodEnd:   time.Date(2022, time.February, 28, 0, 0, 0, 0, time.UTC),
+		Categories: []invoice.Category{
+			{
+				Source: "nest:disposal-plant-p-12a-furnace-control",
+				Target: "1337",
+				Items: []invoice.Item{
+					{
+						Description:   "Memory, with \"quotes\"",
+						QueryName:     "appuio_cloud_memory",
+						ProductRef:    invoice.ProductRef{Source: "appuio_cloud_memory:*:tricell", Target: "1000"},
+						Quantity:      4000,
+						Unit:          "MiB",
+						PricePerUnit:  0.5,
+						PriceCurrency: "EUR",
+						Discount:      0.25,
+						Total:         1500,
+						Currency:      "CHF",
+					},
+				},
+				Currency: "CHF",
+				Total:    1500,
+			},
+		},
+		Total: 1500,
+	},
+}
+
+func TestEncodeJSON(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, invoice.EncodeJSON(&buf, encodeInvoices))
+
+	var decoded []invoice.Invoice
+	dec := json.NewDecoder(&buf)
+	require.NoError(t, dec.Decode(&decoded))
+	assert.Equal(t, encodeInvoices, decoded)
+	assert.False(t, dec.More(), "invoices must be encoded exactly once")
+}
+
+func TestEncodeYAML(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, invoice.EncodeYAML(&buf, encodeInvoices))
+	assert.Contains(t, buf.String(), "- Tenant:\n    Source: tricell\n    Target: \"98942\"\n")
+
+	var decoded []map[string]interface{}
+	require.NoError(t, yaml.Unmarshal(buf.Bytes(), &decoded))
+	require.Len(t, decoded, 1)
+	assert.Equal(t, 1500, decoded[0]["Total"])
+	assert.Equal(t, "2022-02-01T00:00:00Z", decoded[0]["PeriodStart"])
+}
+
+func TestEncodeCSV(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, invoice.EncodeCSV(&buf, encodeInvoices))
+	assert.Equal(t,
+		"tenant_source,tenant_target,period_start,period_end,category_source,category_target,product_source,product_target,query_name,description,quantity,quantity_min,quantity_avg,quantity_max,unit,price_per_unit,price_currency,discount,total,currency\n"+
+			`tricell,98942,2022-02-01,2022-02-28,nest:disposal-plant-p-12a-furnace-control,1337,appuio_cloud_memory:*:tricell,1000,appuio_cloud_memory,"Memory, with ""quotes""",4000,0,0,0,MiB,0.5,EUR,0.25,1500,CHF`+"\n",
+		buf.String())
+}
+
+func TestEncodeTable(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, invoice.EncodeTable(&buf, encodeInvoices))
+	assert.Contains(t, buf.String(), "tricell  Total")
+	assert.Contains(t, buf.String(), "appuio_cloud_memory:*:tricell")
+	assert.Contains(t, buf.String(), "0.5 EUR")
+}
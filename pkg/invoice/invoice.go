@@ -4,19 +4,28 @@ package invoice
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/appuio/appuio-cloud-reporting/pkg/db"
+	"github.com/jackc/pgtype"
 	"github.com/jmoiron/sqlx"
 )
 
+// ErrMixedCurrencies is returned if amounts in different currencies would be summed up.
+var ErrMixedCurrencies = errors.New("mixed currencies")
+
 // Invoice represents an invoice for a tenant.
 type Invoice struct {
 	Tenant Tenant
+	// Currency is the billing currency of the tenant. All totals of the invoice are in this currency.
+	Currency string
 
+	// PeriodStart is the start of the billed period.
 	PeriodStart time.Time
-	PeriodEnd   time.Time
+	// PeriodEnd is the start of the last day of the billed period.
+	PeriodEnd time.Time
 
 	Categories []Category
 	// Total represents the total accumulated cost of the invoice.
@@ -28,6 +37,8 @@ type Category struct {
 	Source string
 	Target string
 	Items  []Item
+	// Currency is the currency of the total.
+	Currency string
 	// Total represents the total accumulated cost per category.
 	Total float64
 }
@@ -50,14 +61,19 @@ type Item struct {
 	QuantityMax float64
 	// Unit represents the unit of the item. e.g. MiB
 	Unit string
-	// PricePerUnit represents the price per unit in Rappen
+	// PricePerUnit represents the price per unit in the currency of the product.
 	PricePerUnit float64
+	// PriceCurrency is the currency of the product and the price per unit.
+	PriceCurrency string
 	// Discount represents a discount in percent. 0.3 discount equals price per unit * 0.7
 	Discount float64
 	// Total represents the total accumulated cost.
 	// (hour1 * quantity * price per unit * discount) + (hour2 * quantity * price
 	// per unit * discount)
+	// Prices in another currency than the billing currency of the tenant are converted with the exchange rate valid at every hour.
 	Total float64
+	// Currency is the currency of the total, the billing currency of the tenant.
+	Currency string
 	// SubItems are entries created by the subqueries of the main invoice item.
 	// The keys are the QueryNames of the sub items.
 	SubItems map[string]SubItem
@@ -95,15 +111,27 @@ type ProductRef struct {
 
 // Generate generates invoices for the given month.
 // No data is written to the database. The transaction can be read-only.
-func Generate(ctx context.Context, tx *sqlx.Tx, year int, month time.Month) ([]Invoice, error) {
-	tenants, err := tenantsForPeriod(ctx, tx, year, month)
+func Generate(ctx context.Context, tx *sqlx.Tx, year int, month time.Month, options ...Option) ([]Invoice, error) {
+	from := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+	return GenerateRange(ctx, tx, from, from.AddDate(0, 1, 0), options...)
+}
+
+// GenerateRange generates invoices for the facts of the hours starting in the period [from, to).
+// The period can span parts of months or multiple months.
+// No data is written to the database. The transaction can be read-only.
+func GenerateRange(ctx context.Context, tx *sqlx.Tx, from, to time.Time, options ...Option) ([]Invoice, error) {
+	opts := buildOptions(options)
+	if !from.Before(to) {
+		return nil, fmt.Errorf("invalid period: %s is not before %s", from.Format(time.RFC3339), to.Format(time.RFC3339))
+	}
+	tenants, err := tenantsForPeriod(ctx, tx, from, to, opts.tenants)
 	if err != nil {
 		return nil, err
 	}
 
 	invoices := make([]Invoice, 0, len(tenants))
 	for _, tenant := range tenants {
-		invoice, err := invoiceForTenant(ctx, tx, tenant, year, month)
+		invoice, err := invoiceForTenant(ctx, tx, tenant, from, to)
 		if err != nil {
 			return nil, err
 		}
@@ -112,43 +140,53 @@ func Generate(ctx context.Context, tx *sqlx.Tx, year int, month time.Month) ([]I
 	return invoices, nil
 }
 
-func invoiceForTenant(ctx context.Context, tx *sqlx.Tx, tenant db.Tenant, year int, month time.Month) (Invoice, error) {
+func invoiceForTenant(ctx context.Context, tx *sqlx.Tx, tenant db.Tenant, from, to time.Time) (Invoice, error) {
 	var categories []db.Category
 	err := sqlx.SelectContext(ctx, tx, &categories,
 		`SELECT DISTINCT categories.*
 			FROM categories
 			INNER JOIN facts ON (facts.category_id = categories.id)
 			INNER JOIN date_times ON (facts.date_time_id = date_times.id)
-			WHERE date_times.year = $1 AND date_times.month = $2
+			WHERE date_times.timestamp >= $1 AND date_times.timestamp < $2
 			AND facts.tenant_id = $3
 			ORDER BY categories.source
 			`,
-		year, int(month), tenant.Id)
+		from, to, tenant.Id)
 
 	if err != nil {
-		return Invoice{}, fmt.Errorf("failed to load categories for %q at %d %s: %w", tenant.Source, year, month.String(), err)
+		return Invoice{}, fmt.Errorf("failed to load categories for %q %s: %w", tenant.Source, formatPeriod(from, to), err)
 	}
 
 	invCategories := make([]Category, 0, len(categories))
 	for _, category := range categories {
-		items, err := itemsForCategory(ctx, tx, tenant, category, year, month)
+		items, err := itemsForCategory(ctx, tx, tenant, category, from, to)
 		if err != nil {
 			return Invoice{}, err
 		}
+		total, err := sumCategoryTotal(tenant.BillingCurrency, items)
+		if err != nil {
+			return Invoice{}, fmt.Errorf("failed to sum up category %q for %q: %w", category.Source, tenant.Source, err)
+		}
 		invCategories = append(invCategories, Category{
-			Source: category.Source,
-			Target: category.Target.String,
-			Items:  items,
-			Total:  sumCategoryTotal(items),
+			Source:   category.Source,
+			Target:   category.Target.String,
+			Items:    items,
+			Currency: tenant.BillingCurrency,
+			Total:    total,
 		})
 	}
 
+	total, err := sumInvoiceTotal(tenant.BillingCurrency, invCategories)
+	if err != nil {
+		return Invoice{}, fmt.Errorf("failed to sum up invoice for %q: %w", tenant.Source, err)
+	}
 	return Invoice{
 		Tenant:      Tenant{Source: tenant.Source, Target: tenant.Target.String},
-		PeriodStart: time.Date(year, month, 1, 0, 0, 0, 0, time.UTC),
-		PeriodEnd:   time.Date(year, month, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 1, -1),
+		Currency:    tenant.BillingCurrency,
+		PeriodStart: from,
+		PeriodEnd:   lastDay(to),
 		Categories:  invCategories,
-		Total:       sumInvoiceTotal(invCategories),
+		Total:       total,
 	}, nil
 }
 
@@ -164,32 +202,47 @@ type rawItem struct {
 	DiscountID string `db:"discount_id"`
 	// ProductID is the id of the corresponding product entry
 	ProductID string `db:"product_ref_id"`
+	// MissingExchangeRates is the number of facts whose price could not be converted to the billing currency
+	MissingExchangeRates int `db:"missing_exchange_rates"`
 }
 
-func itemsForCategory(ctx context.Context, tx *sqlx.Tx, tenant db.Tenant, category db.Category, year int, month time.Month) ([]Item, error) {
+func itemsForCategory(ctx context.Context, tx *sqlx.Tx, tenant db.Tenant, category db.Category, from, to time.Time) ([]Item, error) {
 	var items []rawItem
 	err := sqlx.SelectContext(ctx, tx, &items,
 		`SELECT  queries.id as query_id, queries.parent_id as parent_query_id, discounts.id as discount_id,
 				queries.description, queries.name as queryName,
 				SUM(facts.quantity) as quantity, MIN(facts.quantity) as quantitymin, AVG(facts.quantity) as quantityavg, MAX(facts.quantity) as quantitymax,
-				queries.unit, products.amount AS pricePerUnit, discounts.discount,
+				queries.unit, products.amount AS pricePerUnit, products.currency AS priceCurrency, discounts.discount,
 				products.id as product_ref_id, products.source as product_ref_source, COALESCE(products.target,''::text) as product_ref_target,
-				SUM( facts.quantity * products.amount * ( 1::double precision - discounts.discount ) ) AS total
+				SUM( facts.quantity * products.amount * ( 1::double precision - discounts.discount ) * COALESCE(exchange_rates.rate, 1::double precision) ) AS total,
+				$5::text AS currency,
+				COUNT(*) FILTER (WHERE products.currency <> $5::text AND exchange_rates.id IS NULL) AS missing_exchange_rates
 			FROM facts
 				INNER JOIN tenants    ON (facts.tenant_id = tenants.id)
 				INNER JOIN queries    ON (facts.query_id = queries.id)
 				INNER JOIN discounts  ON (facts.discount_id = discounts.id)
 				INNER JOIN products   ON (facts.product_id = products.id)
 				INNER JOIN date_times ON (facts.date_time_id = date_times.id)
-			WHERE date_times.year = $1 AND date_times.month = $2
+				LEFT JOIN exchange_rates ON (products.currency <> $5::text
+					AND exchange_rates.source_currency = products.currency
+					AND exchange_rates.target_currency = $5::text
+					AND exchange_rates.during @> date_times.timestamp)
+			WHERE date_times.timestamp >= $1 AND date_times.timestamp < $2
 				AND facts.tenant_id = $3
 				AND facts.category_id = $4
 			GROUP BY queries.id, products.id, discounts.id
 		`,
-		year, int(month), tenant.Id, category.Id)
+		from, to, tenant.Id, category.Id, tenant.BillingCurrency)
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to load item for %q/%q at %d %s: %w", tenant.Source, category.Source, year, month.String(), err)
+		return nil, fmt.Errorf("failed to load item for %q/%q %s: %w", tenant.Source, category.Source, formatPeriod(from, to), err)
+	}
+	// The totals of all items are in the billing currency, so they can be summed up, as long as every price could be converted.
+	for _, item := range items {
+		if item.MissingExchangeRates > 0 {
+			return nil, fmt.Errorf("no exchange rate from %s to %s for %d facts of product %q in %q/%q %s",
+				item.PriceCurrency, tenant.BillingCurrency, item.MissingExchangeRates, item.ProductRef.Source, tenant.Source, category.Source, formatPeriod(from, to))
+		}
 	}
 
 	return buildItemHierarchy(items), nil
@@ -232,34 +285,62 @@ func buildItemHierarchy(items []rawItem) []Item {
 	return res
 }
 
-func tenantsForPeriod(ctx context.Context, tx *sqlx.Tx, year int, month time.Month) ([]db.Tenant, error) {
+// tenantsForPeriod returns the tenants with facts in the given period.
+// If filter is not empty, only tenants with a source or target in filter are returned.
+func tenantsForPeriod(ctx context.Context, tx *sqlx.Tx, from, to time.Time, filter []string) ([]db.Tenant, error) {
 	var tenants []db.Tenant
 
+	tenantFilter := pgtype.TextArray{}
+	if err := tenantFilter.Set(append([]string{}, filter...)); err != nil {
+		return nil, err
+	}
+
 	err := sqlx.SelectContext(ctx, tx, &tenants,
 		`SELECT DISTINCT tenants.*
 			FROM tenants
 				INNER JOIN facts ON (facts.tenant_id = tenants.id)
 				INNER JOIN date_times ON (facts.date_time_id = date_times.id)
-			WHERE date_times.year = $1 AND date_times.month = $2
+			WHERE date_times.timestamp >= $1 AND date_times.timestamp < $2
+				AND (cardinality($3::text[]) = 0 OR tenants.source = ANY($3::text[]) OR tenants.target = ANY($3::text[]))
 			ORDER BY tenants.source
 		`,
-		year, int(month))
+		from, to, tenantFilter)
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to load tenants for %d %s: %w", year, month.String(), err)
+		return nil, fmt.Errorf("failed to load tenants %s: %w", formatPeriod(from, to), err)
 	}
 	return tenants, nil
 }
 
-func sumCategoryTotal(itms []Item) (sum float64) {
+// lastDay returns the start of the day before the given exclusive end of a period in UTC.
+func lastDay(to time.Time) time.Time {
+	y, m, d := to.In(time.UTC).Add(-time.Nanosecond).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+func formatPeriod(from, to time.Time) string {
+	return fmt.Sprintf("between %s and %s", from.Format(time.RFC3339), to.Format(time.RFC3339))
+}
+
+// sumCategoryTotal sums up the totals of the items.
+// Returns ErrMixedCurrencies if the total of an item is not in the given currency.
+func sumCategoryTotal(currency string, itms []Item) (sum float64, err error) {
 	for _, itm := range itms {
+		if itm.Currency != currency {
+			return 0, fmt.Errorf("%w: item %q is in %q instead of %q", ErrMixedCurrencies, itm.QueryName, itm.Currency, currency)
+		}
 		sum += itm.Total
 	}
 	return
 }
 
-func sumInvoiceTotal(cat []Category) (sum float64) {
+// sumInvoiceTotal sums up the totals of the categories.
+// Returns ErrMixedCurrencies if the total of a category is not in the given currency.
+func sumInvoiceTotal(currency string, cat []Category) (sum float64, err error) {
 	for _, itm := range cat {
+		if itm.Currency != currency {
+			return 0, fmt.Errorf("%w: category %q is in %q instead of %q", ErrMixedCurrencies, itm.Source, itm.Currency, currency)
+		}
 		sum += itm.Total
 	}
 	return
@@ -296,10 +296,12 @@ func (s *InvoiceSuite) TestInvoice_Generate() {
 			},
 			PeriodStart: time.Date(2021, time.December, 1, 0, 0, 0, 0, time.UTC),
 			PeriodEnd:   time.Date(2021, time.December, 31, 0, 0, 0, 0, time.UTC),
+			Currency:    db.DefaultCurrency,
 			Categories: []invoice.Category{
 				{
-					Source: s.uroborosCategory.Source,
-					Target: s.uroborosCategory.Target.String,
+					Source:   s.uroborosCategory.Source,
+					Target:   s.uroborosCategory.Target.String,
+					Currency: db.DefaultCurrency,
 					Items: []invoice.Item{
 						{
 							Description: s.memoryQuery.Description,
@@ -308,14 +310,16 @@ func (s *InvoiceSuite) TestInvoice_Generate() {
 								Source: s.memoryProduct.Source,
 								Target: s.memoryProduct.Target.String,
 							},
-							Quantity:     quantity,
-							QuantityMin:  quantity,
-							QuantityAvg:  quantity,
-							QuantityMax:  quantity,
-							Unit:         s.memoryQuery.Unit,
-							PricePerUnit: s.memoryProduct.Amount,
-							Discount:     s.memoryDiscount.Discount,
-							Total:        quantity * s.memoryProduct.Amount,
+							Quantity:      quantity,
+							QuantityMin:   quantity,
+							QuantityAvg:   quantity,
+							QuantityMax:   quantity,
+							Unit:          s.memoryQuery.Unit,
+							PricePerUnit:  s.memoryProduct.Amount,
+							PriceCurrency: s.memoryProduct.Currency,
+							Discount:      s.memoryDiscount.Discount,
+							Total:         quantity * s.memoryProduct.Amount,
+							Currency:      db.DefaultCurrency,
 							SubItems: map[string]invoice.SubItem{
 								s.memorySubQuery.Name: {
 									Description: s.memorySubQuery.Description,
@@ -335,14 +339,16 @@ func (s *InvoiceSuite) TestInvoice_Generate() {
 								Source: s.memoryProduct.Source,
 								Target: s.memoryProduct.Target.String,
 							},
-							Quantity:     quantity,
-							QuantityMin:  quantity,
-							QuantityAvg:  quantity,
-							QuantityMax:  quantity,
-							Unit:         s.memoryQuery.Unit,
-							PricePerUnit: s.memoryProduct.Amount,
-							Discount:     s.tricellMemoryDiscount.Discount,
-							Total:        quantity * s.memoryProduct.Amount * 0.5,
+							Quantity:      quantity,
+							QuantityMin:   quantity,
+							QuantityAvg:   quantity,
+							QuantityMax:   quantity,
+							Unit:          s.memoryQuery.Unit,
+							PricePerUnit:  s.memoryProduct.Amount,
+							PriceCurrency: s.memoryProduct.Currency,
+							Discount:      s.tricellMemoryDiscount.Discount,
+							Total:         quantity * s.memoryProduct.Amount * 0.5,
+							Currency:      db.DefaultCurrency,
 							SubItems: map[string]invoice.SubItem{
 								s.memorySubQuery.Name: {
 									Description: s.memorySubQuery.Description,
@@ -381,10 +387,12 @@ func (s *InvoiceSuite) TestInvoice_Generate() {
 			},
 			PeriodStart: time.Date(2021, time.December, 1, 0, 0, 0, 0, time.UTC),
 			PeriodEnd:   time.Date(2021, time.December, 31, 0, 0, 0, 0, time.UTC),
+			Currency:    db.DefaultCurrency,
 			Categories: []invoice.Category{
 				{
-					Source: s.p12aCategory.Source,
-					Target: s.p12aCategory.Target.String,
+					Source:   s.p12aCategory.Source,
+					Target:   s.p12aCategory.Target.String,
+					Currency: db.DefaultCurrency,
 					Items: []invoice.Item{
 						{
 							Description: s.storageQuery.Description,
@@ -393,15 +401,17 @@ func (s *InvoiceSuite) TestInvoice_Generate() {
 								Source: s.storageProduct.Source,
 								Target: s.storageProduct.Target.String,
 							},
-							Quantity:     storP12Quantity * stampsInTimerange,
-							QuantityMin:  storP12Quantity,
-							QuantityAvg:  storP12Quantity,
-							QuantityMax:  storP12Quantity,
-							Unit:         s.storageQuery.Unit,
-							PricePerUnit: s.storageProduct.Amount,
-							Discount:     s.storageDiscount.Discount,
-							Total:        storP12Total,
-							SubItems:     map[string]invoice.SubItem{},
+							Quantity:      storP12Quantity * stampsInTimerange,
+							QuantityMin:   storP12Quantity,
+							QuantityAvg:   storP12Quantity,
+							QuantityMax:   storP12Quantity,
+							Unit:          s.storageQuery.Unit,
+							PricePerUnit:  s.storageProduct.Amount,
+							PriceCurrency: s.storageProduct.Currency,
+							Discount:      s.storageDiscount.Discount,
+							Total:         storP12Total,
+							Currency:      db.DefaultCurrency,
+							SubItems:      map[string]invoice.SubItem{},
 						},
 						{
 							Description: s.memoryQuery.Description,
@@ -410,14 +420,16 @@ func (s *InvoiceSuite) TestInvoice_Generate() {
 								Source: s.memoryProduct.Source,
 								Target: s.memoryProduct.Target.String,
 							},
-							Quantity:     memP12Quantity * stampsInTimerange,
-							QuantityMin:  memP12Quantity,
-							QuantityAvg:  memP12Quantity,
-							QuantityMax:  memP12Quantity,
-							Unit:         s.memoryQuery.Unit,
-							PricePerUnit: s.memoryProduct.Amount,
-							Discount:     s.memoryDiscount.Discount,
-							Total:        memP12Total,
+							Quantity:      memP12Quantity * stampsInTimerange,
+							QuantityMin:   memP12Quantity,
+							QuantityAvg:   memP12Quantity,
+							QuantityMax:   memP12Quantity,
+							Unit:          s.memoryQuery.Unit,
+							PricePerUnit:  s.memoryProduct.Amount,
+							PriceCurrency: s.memoryProduct.Currency,
+							Discount:      s.memoryDiscount.Discount,
+							Total:         memP12Total,
+							Currency:      db.DefaultCurrency,
 							SubItems: map[string]invoice.SubItem{
 								s.memorySubQuery.Name: {
 									Description: s.memorySubQuery.Description,
@@ -443,8 +455,9 @@ func (s *InvoiceSuite) TestInvoice_Generate() {
 					Total: memP12Total + storP12Total,
 				},
 				{
-					Source: s.nestElevCtrlCategory.Source,
-					Target: s.nestElevCtrlCategory.Target.String,
+					Source:   s.nestElevCtrlCategory.Source,
+					Target:   s.nestElevCtrlCategory.Target.String,
+					Currency: db.DefaultCurrency,
 					Items: []invoice.Item{
 						{
 							Description: s.memoryQuery.Description,
@@ -453,15 +466,17 @@ func (s *InvoiceSuite) TestInvoice_Generate() {
 								Source: s.memoryProduct.Source,
 								Target: s.memoryProduct.Target.String,
 							},
-							Quantity:     memNestQuantity * stampsInTimerange,
-							QuantityMin:  memNestQuantity,
-							QuantityAvg:  memNestQuantity,
-							QuantityMax:  memNestQuantity,
-							Unit:         s.memoryQuery.Unit,
-							PricePerUnit: s.memoryProduct.Amount,
-							Discount:     s.memoryDiscount.Discount,
-							Total:        memNestTotal,
-							SubItems:     map[string]invoice.SubItem{},
+							Quantity:      memNestQuantity * stampsInTimerange,
+							QuantityMin:   memNestQuantity,
+							QuantityAvg:   memNestQuantity,
+							QuantityMax:   memNestQuantity,
+							Unit:          s.memoryQuery.Unit,
+							PricePerUnit:  s.memoryProduct.Amount,
+							PriceCurrency: s.memoryProduct.Currency,
+							Discount:      s.memoryDiscount.Discount,
+							Total:         memNestTotal,
+							Currency:      db.DefaultCurrency,
+							SubItems:      map[string]invoice.SubItem{},
 						},
 					},
 					Total: memNestTotal,
@@ -472,6 +487,100 @@ func (s *InvoiceSuite) TestInvoice_Generate() {
 	})
 }
 
+func (s *InvoiceSuite) TestInvoice_GenerateRange() {
+	t := s.T()
+
+	tx, err := s.DB().Beginx()
+	require.NoError(t, err)
+	defer tx.Rollback()
+
+	from := time.Date(2021, time.December, 15, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2022, time.January, 1, 12, 0, 0, 0, time.UTC)
+	invRun, err := invoice.GenerateRange(context.Background(), tx, from, to)
+	require.NoError(t, err)
+	require.Len(t, invRun, 2)
+
+	inv := invRun[0]
+	require.Equal(t, s.tricellTenant.Source, inv.Tenant.Source)
+	require.Equal(t, from, inv.PeriodStart)
+	require.Equal(t, time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC), inv.PeriodEnd)
+	require.NotEmpty(t, inv.Categories)
+	for _, cat := range inv.Categories {
+		for _, item := range cat.Items {
+			require.Equal(t, 2*item.QuantityAvg, item.Quantity, "facts of December 31 and January 1 should be included")
+		}
+	}
+
+	invRun, err = invoice.GenerateRange(context.Background(), tx, from, from.Add(time.Hour))
+	require.NoError(t, err)
+	require.Len(t, invRun, 0)
+
+	_, err = invoice.GenerateRange(context.Background(), tx, to, from)
+	require.Error(t, err)
+}
+
+func (s *InvoiceSuite) TestInvoice_GenerateWithTenants() {
+	t := s.T()
+
+	tx, err := s.DB().Beginx()
+	require.NoError(t, err)
+	defer tx.Rollback()
+
+	invRun, err := invoice.Generate(context.Background(), tx, 2021, time.December, invoice.WithTenants(s.tricellTenant.Source))
+	require.NoError(t, err)
+	require.Len(t, invRun, 1)
+	require.Equal(t, s.tricellTenant.Source, invRun[0].Tenant.Source)
+
+	invRun, err = invoice.Generate(context.Background(), tx, 2021, time.December,
+		invoice.WithTenants(s.tricellTenant.Source), invoice.WithTenants(s.umbrellaCorpTenant.Target.String, "unknown"))
+	require.NoError(t, err)
+	require.Len(t, invRun, 2, "tenants should be matched by source or target")
+
+	invRun, err = invoice.Generate(context.Background(), tx, 2021, time.December, invoice.WithTenants("unknown"))
+	require.NoError(t, err)
+	require.Len(t, invRun, 0)
+}
+
+func (s *InvoiceSuite) TestInvoice_GenerateWithExchangeRates() {
+	t := s.T()
+
+	tx, err := s.DB().Beginx()
+	require.NoError(t, err)
+	defer tx.Rollback()
+
+	expected, err := invoice.Generate(context.Background(), tx, 2021, time.December, invoice.WithTenants(s.tricellTenant.Source))
+	require.NoError(t, err)
+	require.Len(t, expected, 1)
+
+	_, err = tx.Exec("UPDATE tenants SET billing_currency = 'EUR' WHERE id = $1", s.tricellTenant.Id)
+	require.NoError(t, err)
+	_, err = invoice.Generate(context.Background(), tx, 2021, time.December, invoice.WithTenants(s.tricellTenant.Source))
+	require.ErrorContains(t, err, "no exchange rate from CHF to EUR")
+
+	_, err = db.CreateExchangeRate(tx, db.ExchangeRate{
+		SourceCurrency: db.DefaultCurrency,
+		TargetCurrency: "EUR",
+		Rate:           0.5,
+		During:         db.InfiniteRange(),
+	})
+	require.NoError(t, err)
+	invRun, err := invoice.Generate(context.Background(), tx, 2021, time.December, invoice.WithTenants(s.tricellTenant.Source))
+	require.NoError(t, err)
+	require.Len(t, invRun, 1)
+
+	inv := invRun[0]
+	require.Equal(t, "EUR", inv.Currency)
+	require.InDelta(t, expected[0].Total*0.5, inv.Total, 0.0001)
+	for _, cat := range inv.Categories {
+		require.Equal(t, "EUR", cat.Currency)
+		for _, item := range cat.Items {
+			require.Equal(t, "EUR", item.Currency)
+			require.Equal(t, db.DefaultCurrency, item.PriceCurrency)
+			require.Equal(t, s.memoryProduct.Amount, item.PricePerUnit, "price per unit should stay in the currency of the product")
+		}
+	}
+}
+
 func TestInvoice(t *testing.T) {
 	suite.Run(t, new(InvoiceSuite))
 }
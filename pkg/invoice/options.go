@@ -0,0 +1,30 @@
+package invoice
+
+type options struct {
+	tenants []string
+}
+
+// Option represents an invoice option.
+type Option interface {
+	set(*options)
+}
+
+func buildOptions(os []Option) options {
+	var build options
+	for _, o := range os {
+		o.set(&build)
+	}
+	return build
+}
+
+// WithTenants only generates invoices for the tenants with the given sources or targets.
+// Can be given multiple times, the tenants are combined.
+func WithTenants(tenants ...string) Option {
+	return tenantsOption(tenants)
+}
+
+type tenantsOption []string
+
+func (t tenantsOption) set(o *options) {
+	o.tenants = append(o.tenants, t...)
+}
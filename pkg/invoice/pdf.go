@@ -0,0 +1,288 @@
+package invoice
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/go-pdf/fpdf"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const (
+	pdfFont       = "Helvetica"
+	pdfFontSize   = 10
+	pdfLineHeight = 5
+	pdfMargin     = 15
+)
+
+// pdfHeadings maps the heading elements to their font size.
+var pdfHeadings = map[atom.Atom]float64{
+	atom.H1: 18,
+	atom.H2: 14,
+	atom.H3: 12,
+}
+
+// HTMLToPDF converts the given HTML document to an A4 PDF document.
+//
+// Only a subset of HTML is supported. Headings h1 to h3, paragraphs, line breaks, horizontal rules,
+// bold and italic text and tables are rendered. Cells with the class "number" are aligned to the right,
+// rows with the class "subitem" are rendered in gray. Stylesheets and other elements are ignored,
+// the text of unknown elements is rendered as is.
+func HTMLToPDF(w io.Writer, r io.Reader) error {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return fmt.Errorf("failed to parse HTML: %w", err)
+	}
+
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(pdfMargin, pdfMargin, pdfMargin)
+	pdf.SetAutoPageBreak(true, pdfMargin)
+	pdf.AddPage()
+	pdf.SetFont(pdfFont, "", pdfFontSize)
+
+	p := &pdfWriter{pdf: pdf, tr: pdf.UnicodeTranslatorFromDescriptor("")}
+	p.walk(doc)
+	if err := pdf.Error(); err != nil {
+		return err
+	}
+	return pdf.Output(w)
+}
+
+// pdfWriter writes the nodes of an HTML document to a PDF document.
+type pdfWriter struct {
+	pdf *fpdf.Fpdf
+	tr  func(string) string
+
+	bold, italic bool
+}
+
+func (p *pdfWriter) walk(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		text := collapseSpace(n.Data)
+		if left, _, _, _ := p.pdf.GetMargins(); p.pdf.GetX() <= left {
+			text = strings.TrimLeft(text, " ")
+		}
+		if text != "" {
+			p.setFont(pdfFontSize)
+			p.pdf.Write(pdfLineHeight, p.tr(text))
+		}
+		return
+	case html.ElementNode:
+		switch n.DataAtom {
+		case atom.Head, atom.Style, atom.Script, atom.Title:
+			return
+		case atom.Br:
+			p.pdf.Ln(pdfLineHeight)
+			return
+		case atom.Hr:
+			p.newBlock()
+			x, y := p.pdf.GetXY()
+			pageWidth, _ := p.pdf.GetPageSize()
+			p.pdf.Line(x, y, pageWidth-pdfMargin, y)
+			p.pdf.Ln(pdfLineHeight)
+			return
+		case atom.Table:
+			p.newBlock()
+			p.table(n)
+			p.pdf.Ln(pdfLineHeight)
+			return
+		case atom.H1, atom.H2, atom.H3:
+			p.newBlock()
+			size := pdfHeadings[n.DataAtom]
+			p.bold = true
+			p.setFont(size)
+			p.pdf.Write(size/2, p.tr(strings.TrimSpace(collapseSpace(textContent(n)))))
+			p.bold = false
+			p.pdf.Ln(size / 2)
+			p.pdf.Ln(pdfLineHeight / 2)
+			return
+		case atom.P, atom.Div:
+			p.newBlock()
+			defer func() {
+				p.pdf.Ln(pdfLineHeight)
+				p.pdf.Ln(pdfLineHeight / 2)
+			}()
+		case atom.B, atom.Strong:
+			bold := p.bold
+			p.bold = true
+			defer func() { p.bold = bold }()
+		case atom.I, atom.Em:
+			italic := p.italic
+			p.italic = true
+			defer func() { p.italic = italic }()
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		p.walk(c)
+	}
+}
+
+// newBlock starts a new line if the current line is not empty.
+func (p *pdfWriter) newBlock() {
+	if left, _, _, _ := p.pdf.GetMargins(); p.pdf.GetX() > left {
+		p.pdf.Ln(pdfLineHeight)
+	}
+}
+
+func (p *pdfWriter) setFont(size float64) {
+	style := ""
+	if p.bold {
+		style += "B"
+	}
+	if p.italic {
+		style += "I"
+	}
+	p.pdf.SetFont(pdfFont, style, size)
+}
+
+type pdfCell struct {
+	text   string
+	header bool
+	number bool
+}
+
+type pdfRow struct {
+	cells   []pdfCell
+	subitem bool
+}
+
+// table renders the rows of the table with column widths proportional to the widest cell of every column.
+func (p *pdfWriter) table(n *html.Node) {
+	var rows []pdfRow
+	var columns int
+	forEachElement(n, atom.Tr, func(tr *html.Node) {
+		row := pdfRow{subitem: hasClass(tr, "subitem")}
+		for c := tr.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode || (c.DataAtom != atom.Td && c.DataAtom != atom.Th) {
+				continue
+			}
+			row.cells = append(row.cells, pdfCell{
+				text:   p.tr(strings.TrimSpace(collapseSpace(textContent(c)))),
+				header: c.DataAtom == atom.Th,
+				number: hasClass(c, "number"),
+			})
+		}
+		if len(row.cells) > columns {
+			columns = len(row.cells)
+		}
+		rows = append(rows, row)
+	})
+	if columns == 0 {
+		return
+	}
+
+	widths := make([]float64, columns)
+	var total float64
+	for _, row := range rows {
+		for i, cell := range row.cells {
+			p.bold = cell.header
+			p.setFont(pdfFontSize)
+			if w := p.pdf.GetStringWidth(cell.text) + 2*p.pdf.GetCellMargin(); w > widths[i] {
+				widths[i] = w
+			}
+		}
+	}
+	p.bold = false
+	for _, w := range widths {
+		total += w
+	}
+	pageWidth, _ := p.pdf.GetPageSize()
+	available := pageWidth - 2*pdfMargin
+	if total > available {
+		for i := range widths {
+			widths[i] = widths[i] * available / total
+		}
+	}
+
+	for _, row := range rows {
+		if row.subitem {
+			p.pdf.SetTextColor(102, 102, 102)
+		}
+		for i := 0; i < columns; i++ {
+			var cell pdfCell
+			if i < len(row.cells) {
+				cell = row.cells[i]
+			}
+			p.bold = cell.header
+			p.setFont(pdfFontSize)
+			align := "L"
+			if cell.number {
+				align = "R"
+			}
+			p.pdf.CellFormat(widths[i], pdfLineHeight+1, p.fit(cell.text, widths[i]), "B", 0, align, false, 0, "")
+		}
+		p.pdf.Ln(-1)
+		p.pdf.SetTextColor(0, 0, 0)
+	}
+	p.bold = false
+	p.setFont(pdfFontSize)
+}
+
+// fit shortens the text until it fits the given width.
+func (p *pdfWriter) fit(text string, width float64) string {
+	width -= 2 * p.pdf.GetCellMargin()
+	if p.pdf.GetStringWidth(text) <= width {
+		return text
+	}
+	for len(text) > 0 && p.pdf.GetStringWidth(text+"...") > width {
+		text = text[:len(text)-1]
+	}
+	return text + "..."
+}
+
+func forEachElement(n *html.Node, a atom.Atom, f func(*html.Node)) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && c.DataAtom == a {
+			f(c)
+			continue
+		}
+		forEachElement(c, a, f)
+	}
+}
+
+func hasClass(n *html.Node, class string) bool {
+	for _, a := range n.Attr {
+		if a.Key == "class" {
+			for _, c := range strings.Fields(a.Val) {
+				if c == class {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func textContent(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var sb strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && c.DataAtom == atom.Br {
+			sb.WriteString(" ")
+			continue
+		}
+		sb.WriteString(textContent(c))
+	}
+	return sb.String()
+}
+
+// collapseSpace collapses runs of white space into a single space like browsers do.
+// Leading and trailing white space is kept as a single space to separate inline elements.
+func collapseSpace(s string) string {
+	if strings.TrimSpace(s) == "" {
+		return ""
+	}
+	collapsed := strings.Join(strings.Fields(s), " ")
+	if strings.IndexAny(s[:1], " \t\n\r") == 0 {
+		collapsed = " " + collapsed
+	}
+	if strings.IndexAny(s[len(s)-1:], " \t\n\r") == 0 {
+		collapsed += " "
+	}
+	return collapsed
+}
@@ -0,0 +1,149 @@
+package invoice
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultTemplate is the HTML template used if no other template is given.
+// It shows the categories, items, sub-items, discounts and totals of an invoice.
+//
+//go:embed templates/invoice.html.tmpl
+var DefaultTemplate string
+
+// templateFuncs are the functions available in invoice templates.
+var templateFuncs = template.FuncMap{
+	// amount formats quantities and totals with two decimals.
+	"amount": func(f float64) string { return strconv.FormatFloat(f, 'f', 2, 64) },
+	// price formats prices per unit without losing precision.
+	"price": formatFloat,
+	// percent formats a discount of 0.3 as 30%.
+	"percent": func(f float64) string { return strconv.FormatFloat(f*100, 'f', -1, 64) + "%" },
+	"date":    formatDate,
+}
+
+// ParseTemplate parses the given HTML template used to render invoices.
+// The template is executed with an Invoice and can use the functions amount, price, percent and date.
+func ParseTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(templateFuncs).Parse(text)
+}
+
+// RenderHTML renders the invoice with the given template.
+func RenderHTML(w io.Writer, tmpl *template.Template, inv Invoice) error {
+	if err := tmpl.Execute(w, inv); err != nil {
+		return fmt.Errorf("failed to render invoice for %q: %w", inv.Tenant.Source, err)
+	}
+	return nil
+}
+
+// DocumentDir returns the directory of the documents of the invoice in the form of "year/month/tenant".
+// The tenant is the target of the tenant, or the source if the tenant has no target.
+// Invoices of a period other than a calendar month are written to "year/month/start_end/tenant" with the days of the period, year and month of the start.
+func DocumentDir(inv Invoice) string {
+	tenant := inv.Tenant.Target
+	if tenant == "" {
+		tenant = inv.Tenant.Source
+	}
+	return documentDir(inv, tenant)
+}
+
+func documentDir(inv Invoice, tenant string) string {
+	// Tenants must not escape the directory of the month
+	tenant = strings.NewReplacer("/", "_", `\`, "_", "..", "_").Replace(tenant)
+	dir := filepath.Join(
+		strconv.Itoa(inv.PeriodStart.Year()),
+		fmt.Sprintf("%02d", int(inv.PeriodStart.Month())),
+	)
+	if !isCalendarMonth(inv.PeriodStart, inv.PeriodEnd) {
+		dir = filepath.Join(dir, formatDate(inv.PeriodStart)+"_"+formatDate(inv.PeriodEnd))
+	}
+	return filepath.Join(dir, tenant)
+}
+
+// isCalendarMonth returns true if the period starts at the beginning of a month and its last day is the last day of that month.
+func isCalendarMonth(start, lastDay time.Time) bool {
+	start = start.In(time.UTC)
+	y, m, _ := start.Date()
+	month := time.Date(y, m, 1, 0, 0, 0, 0, time.UTC)
+	return start.Equal(month) && lastDay.Equal(month.AddDate(0, 1, -1))
+}
+
+// DocumentDirs returns the DocumentDir of every invoice.
+// Tenants sharing a target get the directory of their source instead, so that they don't overwrite each others documents.
+// Returns an error if the directories of two invoices are still the same.
+func DocumentDirs(invoices []Invoice) ([]string, error) {
+	dirs := make([]string, len(invoices))
+	count := map[string]int{}
+	for i, inv := range invoices {
+		dirs[i] = DocumentDir(inv)
+		count[dirs[i]]++
+	}
+	seen := map[string]string{}
+	for i, inv := range invoices {
+		if count[dirs[i]] > 1 {
+			dirs[i] = documentDir(inv, inv.Tenant.Source)
+		}
+		if other, ok := seen[dirs[i]]; ok {
+			return nil, fmt.Errorf("invoices of %q and %q would both be written to %q", other, inv.Tenant.Source, dirs[i])
+		}
+		seen[dirs[i]] = inv.Tenant.Source
+	}
+	return dirs, nil
+}
+
+// WriteDocuments renders every invoice with the given template into the file "invoice.html" in the directory returned by DocumentDirs below dir.
+// If pdf is true, the rendered HTML is additionally converted to "invoice.pdf". See HTMLToPDF for the supported HTML.
+// Returns the paths of the written files.
+func WriteDocuments(dir string, tmpl *template.Template, pdf bool, invoices []Invoice) ([]string, error) {
+	dirs, err := DocumentDirs(invoices)
+	if err != nil {
+		return nil, err
+	}
+	written := make([]string, 0, len(invoices))
+	for i, inv := range invoices {
+		invDir := filepath.Join(dir, dirs[i])
+		if err := os.MkdirAll(invDir, 0o755); err != nil {
+			return written, fmt.Errorf("failed to create directory for %q: %w", inv.Tenant.Source, err)
+		}
+
+		var html bytes.Buffer
+		if err := RenderHTML(&html, tmpl, inv); err != nil {
+			return written, err
+		}
+		htmlPath := filepath.Join(invDir, "invoice.html")
+		if err := os.WriteFile(htmlPath, html.Bytes(), 0o644); err != nil {
+			return written, fmt.Errorf("failed to write invoice for %q: %w", inv.Tenant.Source, err)
+		}
+		written = append(written, htmlPath)
+
+		if !pdf {
+			continue
+		}
+		pdfPath := filepath.Join(invDir, "invoice.pdf")
+		if err := writePDF(pdfPath, &html); err != nil {
+			return written, fmt.Errorf("failed to write PDF invoice for %q: %w", inv.Tenant.Source, err)
+		}
+		written = append(written, pdfPath)
+	}
+	return written, nil
+}
+
+func writePDF(path string, html io.Reader) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := HTMLToPDF(f, html); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,94 @@
+package invoice_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/appuio/appuio-cloud-reporting/pkg/invoice"
+)
+
+func TestRenderHTML_DefaultTemplate(t *testing.T) {
+	inv := encodeInvoices[0]
+	inv.Categories[0].Items = append([]invoice.Item{}, inv.Categories[0].Items...)
+	inv.Categories[0].Items[0].SubItems = map[string]invoice.SubItem{
+		"appuio_cloud_memory_subquery_cpu_request": {Description: "CPU requests exceeding the fair use limit", Quantity: 1024, Unit: "MiB"},
+	}
+
+	tmpl, err := invoice.ParseTemplate("invoice", invoice.DefaultTemplate)
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, invoice.RenderHTML(&buf, tmpl, inv))
+
+	html := buf.String()
+	assert.Contains(t, html, "2022-02-01 - 2022-02-28")
+	assert.Contains(t, html, "nest:disposal-plant-p-12a-furnace-control")
+	assert.Contains(t, html, "Memory, with &#34;quotes&#34;")
+	assert.Contains(t, html, "CPU requests exceeding the fair use limit")
+	assert.Contains(t, html, "25%")
+	assert.Contains(t, html, "0.5 EUR")
+	assert.Contains(t, html, "1500.00 CHF")
+}
+
+func TestWriteDocuments(t *testing.T) {
+	invoices := []invoice.Invoice{encodeInvoices[0], encodeInvoices[0]}
+	invoices[1].Tenant = invoice.Tenant{Source: "../umbrella"}
+
+	tmpl, err := invoice.ParseTemplate("invoice", invoice.DefaultTemplate)
+	require.NoError(t, err)
+	dir := t.TempDir()
+	written, err := invoice.WriteDocuments(dir, tmpl, true, invoices)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(dir, "2022", "02", "98942", "invoice.html"),
+		filepath.Join(dir, "2022", "02", "98942", "invoice.pdf"),
+		filepath.Join(dir, "2022", "02", "__umbrella", "invoice.html"),
+		filepath.Join(dir, "2022", "02", "__umbrella", "invoice.pdf"),
+	}, written)
+
+	pdf, err := os.ReadFile(written[1])
+	require.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(pdf, []byte("%PDF-")))
+}
+
+func TestDocumentDirs_SharedTarget(t *testing.T) {
+	invoices := []invoice.Invoice{encodeInvoices[0], encodeInvoices[0], encodeInvoices[0]}
+	invoices[0].Tenant = invoice.Tenant{Source: "tricell", Target: "98942"}
+	invoices[1].Tenant = invoice.Tenant{Source: "tricell-labs", Target: "98942"}
+	invoices[2].Tenant = invoice.Tenant{Source: "umbrella", Target: "12345"}
+
+	dirs, err := invoice.DocumentDirs(invoices)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join("2022", "02", "tricell"),
+		filepath.Join("2022", "02", "tricell-labs"),
+		filepath.Join("2022", "02", "12345"),
+	}, dirs)
+
+	invoices[2].Tenant = invoice.Tenant{Source: "tricell"}
+	_, err = invoice.DocumentDirs(invoices)
+	require.Error(t, err)
+}
+
+func TestDocumentDir_Period(t *testing.T) {
+	inv := encodeInvoices[0]
+	assert.Equal(t, filepath.Join("2022", "02", "98942"), invoice.DocumentDir(inv))
+
+	inv.PeriodStart = time.Date(2022, time.February, 1, 0, 0, 0, 0, time.UTC)
+	inv.PeriodEnd = time.Date(2022, time.February, 14, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, filepath.Join("2022", "02", "2022-02-01_2022-02-14", "98942"), invoice.DocumentDir(inv))
+
+	inv.PeriodEnd = time.Date(2022, time.April, 30, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, filepath.Join("2022", "02", "2022-02-01_2022-04-30", "98942"), invoice.DocumentDir(inv))
+}
+
+func TestHTMLToPDF_InvalidTable(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, invoice.HTMLToPDF(&buf, bytes.NewBufferString(`<p>unclosed <b>bold<table><tr><td>a<td>b<tr><td>c</table>`)))
+	assert.True(t, bytes.HasPrefix(buf.Bytes(), []byte("%PDF-")))
+}
@@ -0,0 +1,106 @@
+package pricebook
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/appuio/appuio-cloud-reporting/pkg/db"
+)
+
+// Export returns the queries, query parameters, products and discounts in the database as a price book.
+// No data is written to the database. The transaction can be read-only.
+func Export(ctx context.Context, tx *sqlx.Tx) (PriceBook, error) {
+	var pb PriceBook
+
+	queries, err := db.ListQueries(ctx, tx, nil)
+	if err != nil {
+		return pb, fmt.Errorf("failed to load queries: %w", err)
+	}
+	subQueries := map[string][]Query{}
+	for _, q := range queries {
+		if q.ParentID.Valid {
+			subQueries[q.ParentID.String] = append(subQueries[q.ParentID.String], exportQuery(q))
+		}
+	}
+	for _, q := range queries {
+		if !q.ParentID.Valid {
+			eq := exportQuery(q)
+			eq.SubQueries = subQueries[q.Id]
+			pb.Queries = append(pb.Queries, eq)
+		}
+	}
+
+	params, err := db.ListQueryParameters(ctx, tx, nil)
+	if err != nil {
+		return pb, fmt.Errorf("failed to load query parameters: %w", err)
+	}
+	for _, p := range params {
+		pb.Parameters = append(pb.Parameters, Parameter{
+			Name:   p.Name,
+			Value:  p.Value,
+			During: db.FormatTimerange(p.During),
+		})
+	}
+
+	products, err := db.ListProducts(ctx, tx, nil)
+	if err != nil {
+		return pb, fmt.Errorf("failed to load products: %w", err)
+	}
+	for _, p := range products {
+		currency := p.Currency
+		if currency == db.DefaultCurrency {
+			currency = ""
+		}
+		pb.Products = append(pb.Products, Product{
+			Source:   p.Source,
+			Target:   p.Target.String,
+			Amount:   p.Amount,
+			Unit:     p.Unit,
+			Currency: currency,
+			During:   db.FormatTimerange(p.During),
+		})
+	}
+
+	discounts, err := db.ListDiscounts(ctx, tx, nil)
+	if err != nil {
+		return pb, fmt.Errorf("failed to load discounts: %w", err)
+	}
+	for _, d := range discounts {
+		pb.Discounts = append(pb.Discounts, Discount{
+			Source:   d.Source,
+			Discount: d.Discount,
+			During:   db.FormatTimerange(d.During),
+		})
+	}
+
+	return pb, nil
+}
+
+func exportQuery(q db.Query) Query {
+	eq := Query{
+		Name:        q.Name,
+		Description: q.Description,
+		Query:       q.Query,
+		Unit:        q.Unit,
+		During:      db.FormatTimerange(q.During),
+	}
+	// Only export the range options of range queries to keep the price book short
+	if q.Mode == db.QueryModeRange {
+		eq.Mode = q.Mode
+		eq.RangeStep = q.RangeStep().String()
+		eq.RangeAggregation = q.RangeAggregation
+	}
+	if q.CategoryLabel != "category" || q.ProductLabel != "product" || q.Direct() || q.ClassLabel != "" {
+		eq.Labels = &QueryLabels{
+			Category:  q.CategoryLabel,
+			Product:   q.ProductLabel,
+			Zone:      q.ZoneLabel,
+			Tenant:    q.TenantLabel,
+			Namespace: q.NamespaceLabel,
+			Class:     q.ClassLabel,
+		}
+	}
+	return eq
+}
@@ -0,0 +1,415 @@
+package pricebook
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jackc/pgtype"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/appuio/appuio-cloud-reporting/pkg/db"
+)
+
+// Action describes what a Change does to an entry.
+type Action string
+
+const (
+	// Create creates a new entry.
+	Create Action = "create"
+	// Update updates an existing entry.
+	Update Action = "update"
+	// Delete deletes an existing entry.
+	Delete Action = "delete"
+)
+
+// Change represents a single change needed to reach the state described by a price book.
+type Change struct {
+	Action Action
+	// Kind is the kind of the changed entry. One of query, parameter, product, or discount.
+	Kind string
+	// Key identifies the changed entry in the form of "name@start of validity".
+	Key string
+	// Diff describes the changed fields of an update.
+	Diff []string
+
+	apply func(ctx context.Context, tx *sqlx.Tx) error
+}
+
+// String returns a human readable representation of the change.
+func (c Change) String() string {
+	symbol := map[Action]string{Create: "+", Update: "~", Delete: "-"}[c.Action]
+	s := fmt.Sprintf("%s %s %s", symbol, c.Kind, c.Key)
+	if len(c.Diff) > 0 {
+		s += " (" + strings.Join(c.Diff, ", ") + ")"
+	}
+	return s
+}
+
+// Plan compares the given price book with the queries, query parameters, products and discounts in the database and returns the changes needed to reach the state described by the price book.
+// Entries are identified by their name or source and the start of their validity.
+// Entries not in the price book are only deleted if prune is set.
+// Deletions are ordered before updates and updates before creations.
+func Plan(ctx context.Context, tx *sqlx.Tx, pb PriceBook, prune bool) ([]Change, error) {
+	if err := pb.Validate(); err != nil {
+		return nil, err
+	}
+
+	var deletes, updates, creates []Change
+	for _, plan := range []func(context.Context, *sqlx.Tx, PriceBook, bool) ([]Change, error){planQueries, planParameters, planProducts, planDiscounts} {
+		changes, err := plan(ctx, tx, pb, prune)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range changes {
+			switch c.Action {
+			case Delete:
+				deletes = append(deletes, c)
+			case Update:
+				updates = append(updates, c)
+			case Create:
+				creates = append(creates, c)
+			}
+		}
+	}
+
+	return append(append(deletes, updates...), creates...), nil
+}
+
+// Apply applies the given changes in order.
+func Apply(ctx context.Context, tx *sqlx.Tx, changes []Change) error {
+	for _, c := range changes {
+		if err := c.apply(ctx, tx); err != nil {
+			return fmt.Errorf("failed to %s %s %s: %w", c.Action, c.Kind, c.Key, err)
+		}
+	}
+	return nil
+}
+
+func planQueries(ctx context.Context, tx *sqlx.Tx, pb PriceBook, prune bool) ([]Change, error) {
+	existing, err := db.ListQueries(ctx, tx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load queries: %w", err)
+	}
+	existingKeys := queryKeys(existing)
+	existingByKey := map[string]db.Query{}
+	for _, q := range existing {
+		existingByKey[existingKeys[q.Id]] = q
+	}
+
+	var changes []Change
+	seen := map[string]bool{}
+	var planQuery func(q Query, parentKey string, parentID *string) error
+	planQuery = func(q Query, parentKey string, parentID *string) error {
+		want, _ := q.dbQuery()
+		key := queryEntryKey(q.Name, want.Unit, want.During)
+		if parentKey != "" {
+			key = parentKey + "/" + key
+		}
+		if seen[key] {
+			return fmt.Errorf("duplicate query %s", key)
+		}
+		seen[key] = true
+
+		id := new(string)
+		if cur, ok := existingByKey[key]; ok {
+			*id = cur.Id
+			if diff := diffQuery(cur, want); len(diff) > 0 {
+				want.Id, want.ParentID = cur.Id, cur.ParentID
+				changes = append(changes, Change{Action: Update, Kind: "query", Key: key, Diff: diff,
+					apply: func(ctx context.Context, tx *sqlx.Tx) error {
+						_, err := db.UpdateQuery(ctx, tx, want)
+						return err
+					}})
+			}
+		} else {
+			changes = append(changes, Change{Action: Create, Kind: "query", Key: key,
+				apply: func(ctx context.Context, tx *sqlx.Tx) error {
+					if parentID != nil {
+						want.ParentID = sql.NullString{String: *parentID, Valid: true}
+					}
+					created, err := db.CreateQuery(tx, want)
+					*id = created.Id
+					return err
+				}})
+		}
+
+		for _, sq := range q.SubQueries {
+			if err := planQuery(sq, key, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, q := range pb.Queries {
+		if err := planQuery(q, "", nil); err != nil {
+			return nil, err
+		}
+	}
+
+	if prune {
+		// Delete sub-queries before their parents
+		sort.SliceStable(existing, func(i, j int) bool { return existing[i].ParentID.Valid && !existing[j].ParentID.Valid })
+		for _, q := range existing {
+			q, key := q, existingKeys[q.Id]
+			if seen[key] {
+				continue
+			}
+			changes = append(changes, Change{Action: Delete, Kind: "query", Key: key,
+				apply: func(ctx context.Context, tx *sqlx.Tx) error {
+					return db.DeleteQuery(ctx, tx, q.Id)
+				}})
+		}
+	}
+	return changes, nil
+}
+
+func planParameters(ctx context.Context, tx *sqlx.Tx, pb PriceBook, prune bool) ([]Change, error) {
+	existing, err := db.ListQueryParameters(ctx, tx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load query parameters: %w", err)
+	}
+	existingByKey := map[string]db.QueryParameter{}
+	for _, p := range existing {
+		existingByKey[entryKey(p.Name, p.During)] = p
+	}
+
+	var changes []Change
+	seen := map[string]bool{}
+	for _, p := range pb.Parameters {
+		during, _ := parseDuring(p.During)
+		key := entryKey(p.Name, during)
+		if seen[key] {
+			return nil, fmt.Errorf("duplicate parameter %s", key)
+		}
+		seen[key] = true
+
+		want := db.QueryParameter{Name: p.Name, Value: p.Value, During: during}
+		if cur, ok := existingByKey[key]; ok {
+			if diff := diffParameter(cur, want); len(diff) > 0 {
+				want.Id = cur.Id
+				changes = append(changes, Change{Action: Update, Kind: "parameter", Key: key, Diff: diff,
+					apply: func(ctx context.Context, tx *sqlx.Tx) error {
+						_, err := db.UpdateQueryParameter(ctx, tx, want)
+						return err
+					}})
+			}
+			continue
+		}
+		changes = append(changes, Change{Action: Create, Kind: "parameter", Key: key,
+			apply: func(ctx context.Context, tx *sqlx.Tx) error {
+				_, err := db.CreateQueryParameter(tx, want)
+				return err
+			}})
+	}
+
+	if prune {
+		for _, p := range existing {
+			p, key := p, entryKey(p.Name, p.During)
+			if seen[key] {
+				continue
+			}
+			changes = append(changes, Change{Action: Delete, Kind: "parameter", Key: key,
+				apply: func(ctx context.Context, tx *sqlx.Tx) error {
+					return db.DeleteQueryParameter(ctx, tx, p.Id)
+				}})
+		}
+	}
+	return changes, nil
+}
+
+func planProducts(ctx context.Context, tx *sqlx.Tx, pb PriceBook, prune bool) ([]Change, error) {
+	existing, err := db.ListProducts(ctx, tx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load products: %w", err)
+	}
+	existingByKey := map[string]db.Product{}
+	for _, p := range existing {
+		existingByKey[entryKey(p.Source, p.During)] = p
+	}
+
+	var changes []Change
+	seen := map[string]bool{}
+	for _, p := range pb.Products {
+		during, _ := parseDuring(p.During)
+		key := entryKey(p.Source, during)
+		if seen[key] {
+			return nil, fmt.Errorf("duplicate product %s", key)
+		}
+		seen[key] = true
+
+		want := db.Product{Source: p.Source, Target: db.NullString(p.Target), Amount: p.Amount, Unit: p.Unit, Currency: p.Currency, During: during}
+		if want.Currency == "" {
+			want.Currency = db.DefaultCurrency
+		}
+		if cur, ok := existingByKey[key]; ok {
+			if diff := diffProduct(cur, want); len(diff) > 0 {
+				want.Id = cur.Id
+				changes = append(changes, Change{Action: Update, Kind: "product", Key: key, Diff: diff,
+					apply: func(ctx context.Context, tx *sqlx.Tx) error {
+						_, err := db.UpdateProduct(ctx, tx, want)
+						return err
+					}})
+			}
+			continue
+		}
+		changes = append(changes, Change{Action: Create, Kind: "product", Key: key,
+			apply: func(ctx context.Context, tx *sqlx.Tx) error {
+				_, err := db.CreateProduct(tx, want)
+				return err
+			}})
+	}
+
+	if prune {
+		for _, p := range existing {
+			p, key := p, entryKey(p.Source, p.During)
+			if seen[key] {
+				continue
+			}
+			changes = append(changes, Change{Action: Delete, Kind: "product", Key: key,
+				apply: func(ctx context.Context, tx *sqlx.Tx) error {
+					return db.DeleteProduct(ctx, tx, p.Id)
+				}})
+		}
+	}
+	return changes, nil
+}
+
+func planDiscounts(ctx context.Context, tx *sqlx.Tx, pb PriceBook, prune bool) ([]Change, error) {
+	existing, err := db.ListDiscounts(ctx, tx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load discounts: %w", err)
+	}
+	existingByKey := map[string]db.Discount{}
+	for _, d := range existing {
+		existingByKey[entryKey(d.Source, d.During)] = d
+	}
+
+	var changes []Change
+	seen := map[string]bool{}
+	for _, d := range pb.Discounts {
+		during, _ := parseDuring(d.During)
+		key := entryKey(d.Source, during)
+		if seen[key] {
+			return nil, fmt.Errorf("duplicate discount %s", key)
+		}
+		seen[key] = true
+
+		want := db.Discount{Source: d.Source, Discount: d.Discount, During: during}
+		if cur, ok := existingByKey[key]; ok {
+			if diff := diffDiscount(cur, want); len(diff) > 0 {
+				want.Id = cur.Id
+				changes = append(changes, Change{Action: Update, Kind: "discount", Key: key, Diff: diff,
+					apply: func(ctx context.Context, tx *sqlx.Tx) error {
+						_, err := db.UpdateDiscount(ctx, tx, want)
+						return err
+					}})
+			}
+			continue
+		}
+		changes = append(changes, Change{Action: Create, Kind: "discount", Key: key,
+			apply: func(ctx context.Context, tx *sqlx.Tx) error {
+				_, err := db.CreateDiscount(tx, want)
+				return err
+			}})
+	}
+
+	if prune {
+		for _, d := range existing {
+			d, key := d, entryKey(d.Source, d.During)
+			if seen[key] {
+				continue
+			}
+			changes = append(changes, Change{Action: Delete, Kind: "discount", Key: key,
+				apply: func(ctx context.Context, tx *sqlx.Tx) error {
+					return db.DeleteDiscount(ctx, tx, d.Id)
+				}})
+		}
+	}
+	return changes, nil
+}
+
+// queryKeys returns the keys of the given queries by their id.
+// Keys of sub-queries are prefixed with the key of their parent.
+func queryKeys(queries []db.Query) map[string]string {
+	keys := make(map[string]string, len(queries))
+	for _, q := range queries {
+		if !q.ParentID.Valid {
+			keys[q.Id] = queryEntryKey(q.Name, q.Unit, q.During)
+		}
+	}
+	for _, q := range queries {
+		if q.ParentID.Valid {
+			keys[q.Id] = keys[q.ParentID.String] + "/" + queryEntryKey(q.Name, q.Unit, q.During)
+		}
+	}
+	return keys
+}
+
+// entryKey identifies an entry by its name or source and the start of its validity.
+func entryKey(name string, during pgtype.Tstzrange) string {
+	return name + "@" + db.FormatTimestamp(during.Lower)
+}
+
+// queryEntryKey identifies a query by its name, unit and the start of its validity.
+// Queries with the same name but different units may overlap.
+func queryEntryKey(name, unit string, during pgtype.Tstzrange) string {
+	return name + "[" + unit + "]@" + db.FormatTimestamp(during.Lower)
+}
+
+func diffQuery(cur, want db.Query) []string {
+	var diff []string
+	diff = appendDiff(diff, "description", cur.Description, want.Description)
+	diff = appendDiff(diff, "query", cur.Query, want.Query)
+	diff = appendDiff(diff, "unit", cur.Unit, want.Unit)
+	diff = appendDiff(diff, "during", db.FormatTimerange(cur.During), db.FormatTimerange(want.During))
+	diff = appendDiff(diff, "mode", cur.Mode, want.Mode)
+	// Range options have no effect on instant queries
+	if cur.Mode == db.QueryModeRange || want.Mode == db.QueryModeRange {
+		diff = appendDiff(diff, "rangeStep", cur.RangeStep().String(), want.RangeStep().String())
+		diff = appendDiff(diff, "rangeAggregation", cur.RangeAggregation, want.RangeAggregation)
+	}
+	diff = appendDiff(diff, "labels.category", cur.CategoryLabel, want.CategoryLabel)
+	diff = appendDiff(diff, "labels.product", cur.ProductLabel, want.ProductLabel)
+	diff = appendDiff(diff, "labels.zone", cur.ZoneLabel, want.ZoneLabel)
+	diff = appendDiff(diff, "labels.tenant", cur.TenantLabel, want.TenantLabel)
+	diff = appendDiff(diff, "labels.namespace", cur.NamespaceLabel, want.NamespaceLabel)
+	diff = appendDiff(diff, "labels.class", cur.ClassLabel, want.ClassLabel)
+	return diff
+}
+
+func diffParameter(cur, want db.QueryParameter) []string {
+	var diff []string
+	diff = appendDiff(diff, "value", cur.Value, want.Value)
+	diff = appendDiff(diff, "during", db.FormatTimerange(cur.During), db.FormatTimerange(want.During))
+	return diff
+}
+
+func diffProduct(cur, want db.Product) []string {
+	var diff []string
+	diff = appendDiff(diff, "target", cur.Target.String, want.Target.String)
+	diff = appendDiff(diff, "amount", fmt.Sprint(cur.Amount), fmt.Sprint(want.Amount))
+	diff = appendDiff(diff, "unit", cur.Unit, want.Unit)
+	diff = appendDiff(diff, "currency", cur.Currency, want.Currency)
+	diff = appendDiff(diff, "during", db.FormatTimerange(cur.During), db.FormatTimerange(want.During))
+	return diff
+}
+
+func diffDiscount(cur, want db.Discount) []string {
+	var diff []string
+	diff = appendDiff(diff, "discount", fmt.Sprint(cur.Discount), fmt.Sprint(want.Discount))
+	diff = appendDiff(diff, "during", db.FormatTimerange(cur.During), db.FormatTimerange(want.During))
+	return diff
+}
+
+func appendDiff(diff []string, field, cur, want string) []string {
+	if cur == want {
+		return diff
+	}
+	if strings.Contains(cur, "\n") || strings.Contains(want, "\n") {
+		return append(diff, field+" changed")
+	}
+	return append(diff, fmt.Sprintf("%s: %q -> %q", field, cur, want))
+}
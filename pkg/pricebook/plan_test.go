@@ -0,0 +1,111 @@
+package pricebook_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/appuio/appuio-cloud-reporting/pkg/db"
+	"github.com/appuio/appuio-cloud-reporting/pkg/db/dbtest"
+	"github.com/appuio/appuio-cloud-reporting/pkg/pricebook"
+)
+
+type PlanSuite struct {
+	dbtest.Suite
+}
+
+func (s *PlanSuite) TestPlanApplyExport() {
+	t := s.T()
+	ctx := context.Background()
+	tx := s.Begin()
+	defer tx.Rollback()
+
+	_, err := tx.Exec("DELETE FROM queries")
+	require.NoError(t, err)
+
+	pb, err := pricebook.Load(strings.NewReader(yamlPriceBook))
+	require.NoError(t, err)
+
+	changes, err := pricebook.Plan(ctx, tx, pb, false)
+	require.NoError(t, err)
+	require.Len(t, changes, 5)
+	for _, c := range changes {
+		require.Equal(t, pricebook.Create, c.Action)
+	}
+	require.NoError(t, pricebook.Apply(ctx, tx, changes))
+
+	var subQueryCount int
+	require.NoError(t, tx.Get(&subQueryCount, "SELECT COUNT(*) FROM queries WHERE parent_id IS NOT NULL"))
+	require.Equal(t, 1, subQueryCount)
+
+	changes, err = pricebook.Plan(ctx, tx, pb, true)
+	require.NoError(t, err)
+	require.Empty(t, changes, "applying the same price book twice should not change anything")
+
+	pb.Products[1].Amount = 0.3
+	pb.Discounts = nil
+	changes, err = pricebook.Plan(ctx, tx, pb, true)
+	require.NoError(t, err)
+	require.Len(t, changes, 2)
+	require.Equal(t, pricebook.Delete, changes[0].Action)
+	require.Equal(t, "discount", changes[0].Kind)
+	require.Equal(t, pricebook.Update, changes[1].Action)
+	require.Equal(t, "product", changes[1].Kind)
+	require.NoError(t, pricebook.Apply(ctx, tx, changes))
+
+	exported, err := pricebook.Export(ctx, tx)
+	require.NoError(t, err)
+	changes, err = pricebook.Plan(ctx, tx, exported, true)
+	require.NoError(t, err)
+	require.Empty(t, changes, "an exported price book should match the database")
+	require.Equal(t, 0.3, exported.Products[1].Amount)
+	require.Equal(t, "EUR", exported.Products[1].Currency)
+	require.Empty(t, exported.Products[0].Currency, "the default currency should be omitted")
+}
+
+func (s *PlanSuite) TestPlan_QueriesWithDifferentUnits() {
+	t := s.T()
+	ctx := context.Background()
+	tx := s.Begin()
+	defer tx.Rollback()
+
+	_, err := tx.Exec("DELETE FROM queries")
+	require.NoError(t, err)
+
+	pb := pricebook.PriceBook{Queries: []pricebook.Query{
+		{Name: "test_units", Query: "sum(memory)", Unit: "MiB"},
+		{Name: "test_units", Query: "sum(memory) / 1024", Unit: "GiB"},
+	}}
+	changes, err := pricebook.Plan(ctx, tx, pb, false)
+	require.NoError(t, err)
+	require.Len(t, changes, 2)
+	require.NotEqual(t, changes[0].Key, changes[1].Key)
+	require.NoError(t, pricebook.Apply(ctx, tx, changes))
+
+	changes, err = pricebook.Plan(ctx, tx, pb, true)
+	require.NoError(t, err)
+	require.Empty(t, changes)
+}
+
+func (s *PlanSuite) TestApply_Overlapping() {
+	t := s.T()
+	ctx := context.Background()
+	tx := s.Begin()
+	defer tx.Rollback()
+
+	_, err := db.CreateProduct(tx, db.Product{Source: "test_overlap", During: db.InfiniteRange()})
+	require.NoError(t, err)
+
+	changes, err := pricebook.Plan(ctx, tx, pricebook.PriceBook{Products: []pricebook.Product{
+		{Source: "test_overlap", During: "2022-01-01T00:00:00Z,"},
+	}}, false)
+	require.NoError(t, err)
+	require.ErrorIs(t, pricebook.Apply(ctx, tx, changes), db.ErrOverlappingTimerange)
+}
+
+func TestPlan(t *testing.T) {
+	suite.Run(t, new(PlanSuite))
+}
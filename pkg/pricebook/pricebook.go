@@ -0,0 +1,236 @@
+// Package pricebook allows managing queries, query parameters, products and discounts declaratively.
+package pricebook
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/jackc/pgtype"
+	"gopkg.in/yaml.v3"
+
+	"github.com/appuio/appuio-cloud-reporting/pkg/db"
+	"github.com/appuio/appuio-cloud-reporting/pkg/promql"
+	"github.com/appuio/appuio-cloud-reporting/pkg/sourcekey"
+)
+
+// PriceBook represents the desired state of queries, query parameters, products and discounts.
+type PriceBook struct {
+	Queries    []Query     `json:"queries,omitempty" yaml:"queries,omitempty"`
+	Parameters []Parameter `json:"parameters,omitempty" yaml:"parameters,omitempty"`
+	Products   []Product   `json:"products,omitempty" yaml:"products,omitempty"`
+	Discounts  []Discount  `json:"discounts,omitempty" yaml:"discounts,omitempty"`
+}
+
+// Query represents a query and its sub-queries in the price book.
+type Query struct {
+	Name        string `json:"name" yaml:"name"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+	Query       string `json:"query" yaml:"query"`
+	Unit        string `json:"unit" yaml:"unit"`
+	// During is the validity of the query in the form of "[from,until)". An empty value is unbounded.
+	During string `json:"during,omitempty" yaml:"during,omitempty"`
+	// Mode is either "instant" or "range". Defaults to "instant".
+	Mode string `json:"mode,omitempty" yaml:"mode,omitempty"`
+	// RangeStep is the resolution of range queries in the form of a duration (example: 1m). Defaults to one minute.
+	RangeStep string `json:"rangeStep,omitempty" yaml:"rangeStep,omitempty"`
+	// RangeAggregation is one of "avg", "max" or "sum". Defaults to "avg".
+	RangeAggregation string `json:"rangeAggregation,omitempty" yaml:"rangeAggregation,omitempty"`
+	// Labels maps the labels of the returned samples to the source key and category.
+	Labels *QueryLabels `json:"labels,omitempty" yaml:"labels,omitempty"`
+
+	SubQueries []Query `json:"subQueries,omitempty" yaml:"subQueries,omitempty"`
+}
+
+// QueryLabels holds the label names of a query. See db.QueryLabels for their meaning.
+type QueryLabels struct {
+	// Category defaults to "category".
+	Category string `json:"category,omitempty" yaml:"category,omitempty"`
+	// Product defaults to "product".
+	Product   string `json:"product,omitempty" yaml:"product,omitempty"`
+	Zone      string `json:"zone,omitempty" yaml:"zone,omitempty"`
+	Tenant    string `json:"tenant,omitempty" yaml:"tenant,omitempty"`
+	Namespace string `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+	Class     string `json:"class,omitempty" yaml:"class,omitempty"`
+}
+
+// Parameter represents a query parameter in the price book.
+type Parameter struct {
+	Name  string `json:"name" yaml:"name"`
+	Value string `json:"value" yaml:"value"`
+	// During is the validity of the parameter in the form of "[from,until)". An empty value is unbounded.
+	During string `json:"during,omitempty" yaml:"during,omitempty"`
+}
+
+// Product represents a product in the price book.
+type Product struct {
+	Source string  `json:"source" yaml:"source"`
+	Target string  `json:"target,omitempty" yaml:"target,omitempty"`
+	Amount float64 `json:"amount" yaml:"amount"`
+	Unit   string  `json:"unit" yaml:"unit"`
+	// Currency is the ISO 4217 code of the currency of the amount. An empty value is the default currency CHF.
+	Currency string `json:"currency,omitempty" yaml:"currency,omitempty"`
+	// During is the validity of the product in the form of "[from,until)". An empty value is unbounded.
+	During string `json:"during,omitempty" yaml:"during,omitempty"`
+}
+
+// Discount represents a discount in the price book.
+type Discount struct {
+	Source   string  `json:"source" yaml:"source"`
+	Discount float64 `json:"discount" yaml:"discount"`
+	// During is the validity of the discount in the form of "[from,until)". An empty value is unbounded.
+	During string `json:"during,omitempty" yaml:"during,omitempty"`
+}
+
+// Load reads a price book in the YAML or JSON format and validates it.
+func Load(r io.Reader) (PriceBook, error) {
+	var pb PriceBook
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+	if err := dec.Decode(&pb); err != nil && err != io.EOF {
+		return pb, fmt.Errorf("failed to decode price book: %w", err)
+	}
+	return pb, pb.Validate()
+}
+
+// Validate checks the timeranges, sources and discounts of the price book.
+func (pb PriceBook) Validate() error {
+	for _, q := range pb.Queries {
+		if err := validateQuery(q, false); err != nil {
+			return err
+		}
+	}
+	for _, p := range pb.Parameters {
+		if p.Name == "" {
+			return fmt.Errorf("invalid parameter: name is required")
+		}
+		if _, err := parseDuring(p.During); err != nil {
+			return fmt.Errorf("invalid parameter %q: %w", p.Name, err)
+		}
+	}
+	for _, p := range pb.Products {
+		if err := sourcekey.ValidateLookupKey(p.Source); err != nil {
+			return fmt.Errorf("invalid product %q: %w", p.Source, err)
+		}
+		if p.Currency != "" {
+			if err := db.ValidateCurrency(p.Currency); err != nil {
+				return fmt.Errorf("invalid product %q: %w", p.Source, err)
+			}
+		}
+		if _, err := parseDuring(p.During); err != nil {
+			return fmt.Errorf("invalid product %q: %w", p.Source, err)
+		}
+	}
+	for _, d := range pb.Discounts {
+		if err := sourcekey.ValidateLookupKey(d.Source); err != nil {
+			return fmt.Errorf("invalid discount %q: %w", d.Source, err)
+		}
+		if d.Discount < 0 || d.Discount > 1 {
+			return fmt.Errorf("invalid discount %q: discount %g must be between 0 and 1", d.Source, d.Discount)
+		}
+		if _, err := parseDuring(d.During); err != nil {
+			return fmt.Errorf("invalid discount %q: %w", d.Source, err)
+		}
+	}
+	return nil
+}
+
+func validateQuery(q Query, isSubQuery bool) error {
+	if q.Name == "" {
+		return fmt.Errorf("invalid query: name is required")
+	}
+	if _, err := parseDuring(q.During); err != nil {
+		return fmt.Errorf("invalid query %q: %w", q.Name, err)
+	}
+	if _, err := q.dbQuery(); err != nil {
+		return fmt.Errorf("invalid query %q: %w", q.Name, err)
+	}
+	if err := promql.Validate(q.Query); err != nil {
+		return fmt.Errorf("invalid query %q: %w", q.Name, err)
+	}
+	if isSubQuery && len(q.SubQueries) > 0 {
+		return fmt.Errorf("invalid query %q: sub-queries can't have sub-queries", q.Name)
+	}
+	for _, sq := range q.SubQueries {
+		if err := validateQuery(sq, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dbQuery converts the query without its sub-queries and parent.
+// Empty mode and range options are set to their defaults.
+func (q Query) dbQuery() (db.Query, error) {
+	during, err := parseDuring(q.During)
+	if err != nil {
+		return db.Query{}, err
+	}
+	dq := db.Query{Name: q.Name, Description: q.Description, Query: q.Query, Unit: q.Unit, During: during,
+		Mode: db.QueryModeInstant, RangeStepSeconds: 60, RangeAggregation: db.RangeAggregationAvg,
+		QueryLabels: db.QueryLabels{CategoryLabel: "category", ProductLabel: "product"}}
+	if l := q.Labels; l != nil {
+		if l.Category != "" {
+			dq.CategoryLabel = l.Category
+		}
+		if l.Product != "" {
+			dq.ProductLabel = l.Product
+		}
+		dq.ZoneLabel, dq.TenantLabel, dq.NamespaceLabel, dq.ClassLabel = l.Zone, l.Tenant, l.Namespace, l.Class
+		if dq.Direct() && (l.Zone == "" || l.Tenant == "" || l.Namespace == "") {
+			return dq, fmt.Errorf("zone, tenant and namespace labels must be set together")
+		}
+	}
+	switch q.Mode {
+	case "":
+	case db.QueryModeInstant, db.QueryModeRange:
+		dq.Mode = q.Mode
+	default:
+		return dq, fmt.Errorf("unknown mode %q", q.Mode)
+	}
+	switch q.RangeAggregation {
+	case "":
+	case db.RangeAggregationAvg, db.RangeAggregationMax, db.RangeAggregationSum:
+		dq.RangeAggregation = q.RangeAggregation
+	default:
+		return dq, fmt.Errorf("unknown range aggregation %q", q.RangeAggregation)
+	}
+	if q.RangeStep != "" {
+		step, err := time.ParseDuration(q.RangeStep)
+		if err != nil {
+			return dq, fmt.Errorf("invalid range step: %w", err)
+		}
+		if step < time.Second || step%time.Second != 0 {
+			return dq, fmt.Errorf("invalid range step %s: must be a multiple of one second", step)
+		}
+		dq.RangeStepSeconds = int(step / time.Second)
+	}
+	return dq, nil
+}
+
+// EncodeYAML writes the price book in the YAML format.
+func (pb PriceBook) EncodeYAML(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(pb); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// EncodeJSON writes the price book in the JSON format.
+func (pb PriceBook) EncodeJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "\t")
+	return enc.Encode(pb)
+}
+
+// parseDuring parses the given timerange. An empty timerange is unbounded.
+func parseDuring(during string) (pgtype.Tstzrange, error) {
+	if during == "" {
+		return db.InfiniteRange(), nil
+	}
+	return db.ParseTimerange(during)
+}
@@ -0,0 +1,99 @@
+package pricebook_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/appuio/appuio-cloud-reporting/pkg/pricebook"
+)
+
+const yamlPriceBook = `
+queries:
+  - name: appuio_cloud_memory
+    query: sum(memory)
+    unit: MiB
+    subQueries:
+      - name: appuio_cloud_memory_subquery_cpu_request
+        query: sum(cpu)
+        unit: MiB
+products:
+  - source: appuio_cloud_memory:c-appuio-cloudscale-lpg-2
+    amount: 0.5
+    unit: MiB
+    during: "[-infinity,2022-01-01T00:00:00Z)"
+  - source: appuio_cloud_memory:c-appuio-cloudscale-lpg-2
+    amount: 0.4
+    unit: MiB
+    currency: EUR
+    during: "2022-01-01T00:00:00Z,"
+discounts:
+  - source: appuio_cloud_memory:*:acme-corp
+    discount: 0.2
+`
+
+const jsonPriceBook = `{
+	"products": [
+		{"source": "appuio_cloud_memory:c-appuio-cloudscale-lpg-2", "amount": 0.5, "unit": "MiB"}
+	]
+}`
+
+func TestLoad(t *testing.T) {
+	pb, err := pricebook.Load(strings.NewReader(yamlPriceBook))
+	require.NoError(t, err)
+	require.Len(t, pb.Queries, 1)
+	require.Len(t, pb.Queries[0].SubQueries, 1)
+	require.Len(t, pb.Products, 2)
+	require.Equal(t, 0.4, pb.Products[1].Amount)
+	require.Equal(t, "EUR", pb.Products[1].Currency)
+	require.Len(t, pb.Discounts, 1)
+
+	pb, err = pricebook.Load(strings.NewReader(jsonPriceBook))
+	require.NoError(t, err)
+	require.Len(t, pb.Products, 1)
+
+	pb, err = pricebook.Load(strings.NewReader(""))
+	require.NoError(t, err)
+	require.Empty(t, pb.Products)
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	for name, raw := range map[string]string{
+		"UnknownField":       "products: [{source: foo, price: 1}]",
+		"InvalidSource":      "products: [{source: 'foo:*'}]",
+		"InvalidDuring":      "products: [{source: foo, during: 'yesterday,'}]",
+		"InvalidCurrency":    "products: [{source: foo, currency: euro}]",
+		"DiscountOutOfRange": "discounts: [{source: foo, discount: 1.5}]",
+		"MissingQueryName":   "queries: [{query: foo}]",
+		"NestedSubQueries":   "queries: [{name: a, subQueries: [{name: b, subQueries: [{name: c}]}]}]",
+		"UnknownMode":        "queries: [{name: a, mode: matrix}]",
+		"UnknownAggregation": "queries: [{name: a, mode: range, rangeAggregation: median}]",
+		"InvalidRangeStep":   "queries: [{name: a, mode: range, rangeStep: 500ms}]",
+		"IncompleteLabels":   "queries: [{name: a, labels: {tenant: tenant_id}}]",
+		"MissingParamName":   "parameters: [{value: '1'}]",
+		"InvalidPromQL":      "queries: [{name: a, query: 'sum(foo'}]",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := pricebook.Load(strings.NewReader(raw))
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestEncodeYAML_RoundTrip(t *testing.T) {
+	pb, err := pricebook.Load(strings.NewReader(yamlPriceBook))
+	require.NoError(t, err)
+
+	var buf strings.Builder
+	require.NoError(t, pb.EncodeYAML(&buf))
+	encoded, err := pricebook.Load(strings.NewReader(buf.String()))
+	require.NoError(t, err)
+	require.Equal(t, pb, encoded)
+
+	buf.Reset()
+	require.NoError(t, pb.EncodeJSON(&buf))
+	encoded, err = pricebook.Load(strings.NewReader(buf.String()))
+	require.NoError(t, err)
+	require.Equal(t, pb, encoded)
+}
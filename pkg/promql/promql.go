@@ -0,0 +1,256 @@
+// Package promql validates and renders the PromQL of queries.
+package promql
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// IsTemplate returns true if the query contains template actions and must be rendered before it can be parsed.
+func IsTemplate(query string) bool {
+	return strings.Contains(query, "{{")
+}
+
+// Render executes the query as a text/template with the given query parameters as data.
+// Parameters are accessed by name, for example `{{ .memory_per_core }}`. Missing parameters are an error.
+// Queries without template actions are returned unchanged.
+func Render(name, query string, params map[string]string) (string, error) {
+	if !IsTemplate(query) {
+		return query, nil
+	}
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(query)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse query template: %w", err)
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, params); err != nil {
+		return "", fmt.Errorf("failed to render query template: %w", err)
+	}
+	return b.String(), nil
+}
+
+// Validate checks the syntax of the query.
+// Templates can only be rendered with the parameters valid at a specific time, so only the template syntax is checked for templates.
+func Validate(query string) error {
+	if IsTemplate(query) {
+		if _, err := template.New("").Parse(query); err != nil {
+			return fmt.Errorf("invalid query template: %w", err)
+		}
+		return nil
+	}
+	_, err := Parse(query)
+	return err
+}
+
+// Parse parses the query and checks that it returns an instant vector, a range vector or a scalar.
+func Parse(query string) (parser.Expr, error) {
+	expr, err := parser.ParseExpr(query)
+	if err != nil {
+		return nil, fmt.Errorf("invalid PromQL: %w", err)
+	}
+	if expr.Type() == parser.ValueTypeString {
+		return nil, fmt.Errorf("invalid PromQL: expected query to return a vector, got %s", expr.Type())
+	}
+	return expr, nil
+}
+
+// MissingLabels returns the labels of required which the expression can't produce, sorted by name.
+// The check is conservative: a label is only missing if no series returned by the expression can have it.
+func MissingLabels(expr parser.Expr, required ...string) []string {
+	labels := outputLabels(expr)
+	var missing []string
+	for _, l := range required {
+		if !labels.has(l) {
+			missing = append(missing, l)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// labelSet is the set of labels the series of an expression can have.
+// If any is set, the series can have arbitrary labels, for example labels of selected series, except the labels in set.
+type labelSet struct {
+	any bool
+	set map[string]bool
+}
+
+func anyLabels() labelSet {
+	return labelSet{any: true, set: map[string]bool{}}
+}
+
+func newLabelSet(labels ...string) labelSet {
+	s := labelSet{set: map[string]bool{}}
+	for _, l := range labels {
+		s.set[l] = true
+	}
+	return s
+}
+
+func (s labelSet) has(label string) bool {
+	return s.any != s.set[label]
+}
+
+func (s labelSet) clone() labelSet {
+	n := labelSet{any: s.any, set: make(map[string]bool, len(s.set))}
+	for l := range s.set {
+		n.set[l] = true
+	}
+	return n
+}
+
+func (s labelSet) with(labels ...string) labelSet {
+	n := s.clone()
+	for _, l := range labels {
+		if n.any {
+			delete(n.set, l)
+		} else {
+			n.set[l] = true
+		}
+	}
+	return n
+}
+
+func (s labelSet) without(labels ...string) labelSet {
+	n := s.clone()
+	for _, l := range labels {
+		if n.any {
+			n.set[l] = true
+		} else {
+			delete(n.set, l)
+		}
+	}
+	return n
+}
+
+func (s labelSet) keep(labels ...string) labelSet {
+	n := newLabelSet()
+	for _, l := range labels {
+		if s.has(l) {
+			n.set[l] = true
+		}
+	}
+	return n
+}
+
+func (s labelSet) union(o labelSet) labelSet {
+	if !s.any && !o.any {
+		return s.with(o.list()...)
+	}
+	if !s.any {
+		s, o = o, s
+	}
+	n := anyLabels()
+	for l := range s.set {
+		if !o.has(l) {
+			n.set[l] = true
+		}
+	}
+	return n
+}
+
+// list returns the labels of a set without any.
+func (s labelSet) list() []string {
+	l := make([]string, 0, len(s.set))
+	for k := range s.set {
+		l = append(l, k)
+	}
+	return l
+}
+
+// outputLabels returns the labels the series returned by the expression can have.
+func outputLabels(expr parser.Expr) labelSet {
+	switch e := expr.(type) {
+	case *parser.ParenExpr:
+		return outputLabels(e.Expr)
+	case *parser.StepInvariantExpr:
+		return outputLabels(e.Expr)
+	case *parser.UnaryExpr:
+		return outputLabels(e.Expr)
+	case *parser.SubqueryExpr:
+		return outputLabels(e.Expr)
+	case *parser.MatrixSelector:
+		return outputLabels(e.VectorSelector)
+	case *parser.VectorSelector:
+		return anyLabels()
+	case *parser.NumberLiteral, *parser.StringLiteral:
+		return newLabelSet()
+	case *parser.AggregateExpr:
+		inner := outputLabels(e.Expr)
+		switch e.Op {
+		case parser.TOPK, parser.BOTTOMK:
+			return inner
+		}
+		var s labelSet
+		if e.Without {
+			s = inner.without(e.Grouping...)
+		} else {
+			s = inner.keep(e.Grouping...)
+		}
+		if e.Op == parser.COUNT_VALUES {
+			if l, ok := e.Param.(*parser.StringLiteral); ok {
+				s = s.with(l.Val)
+			}
+		}
+		return s
+	case *parser.Call:
+		return callLabels(e)
+	case *parser.BinaryExpr:
+		return binaryLabels(e)
+	}
+	return anyLabels()
+}
+
+func callLabels(e *parser.Call) labelSet {
+	switch e.Func.Name {
+	case "vector", "time", "scalar", "pi":
+		return newLabelSet()
+	case "absent", "absent_over_time":
+		return anyLabels()
+	case "label_replace", "label_join":
+		s := outputLabels(e.Args[0])
+		if dst, ok := e.Args[1].(*parser.StringLiteral); ok {
+			return s.with(dst.Val)
+		}
+		return anyLabels()
+	}
+	// Other functions keep the labels of their vector argument
+	for _, arg := range e.Args {
+		if t := arg.Type(); t == parser.ValueTypeVector || t == parser.ValueTypeMatrix {
+			return outputLabels(arg)
+		}
+	}
+	return newLabelSet()
+}
+
+func binaryLabels(e *parser.BinaryExpr) labelSet {
+	lhs, rhs := outputLabels(e.LHS), outputLabels(e.RHS)
+	if e.LHS.Type() == parser.ValueTypeScalar {
+		return rhs
+	}
+	if e.RHS.Type() == parser.ValueTypeScalar || e.VectorMatching == nil {
+		return lhs
+	}
+
+	m := e.VectorMatching
+	switch e.Op {
+	case parser.LOR:
+		return lhs.union(rhs)
+	case parser.LAND, parser.LUNLESS:
+		return lhs
+	}
+	switch m.Card {
+	case parser.CardManyToOne:
+		return lhs.with(m.Include...)
+	case parser.CardOneToMany:
+		return rhs.with(m.Include...)
+	}
+	if m.On {
+		return lhs.keep(m.MatchingLabels...)
+	}
+	return lhs.without(m.MatchingLabels...)
+}
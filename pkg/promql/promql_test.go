@@ -0,0 +1,64 @@
+package promql_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/appuio/appuio-cloud-reporting/pkg/db"
+	"github.com/appuio/appuio-cloud-reporting/pkg/promql"
+)
+
+func TestValidate_DefaultQueries(t *testing.T) {
+	for _, q := range db.DefaultQueries {
+		require.NoError(t, promql.Validate(q.Query), q.Name)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, promql.Validate(`vector({{ .value }})`), "templates are only checked for template syntax")
+	assert.Error(t, promql.Validate(`vector({{ .value )`))
+	assert.Error(t, promql.Validate(`sum(up`))
+	assert.Error(t, promql.Validate(`"string"`))
+	assert.Error(t, promql.Validate(``))
+}
+
+func TestRender(t *testing.T) {
+	r, err := promql.Render("test", `vector({{ .value }})`, map[string]string{"value": "4"})
+	require.NoError(t, err)
+	assert.Equal(t, `vector(4)`, r)
+
+	_, err = promql.Render("test", `vector({{ .value }})`, map[string]string{})
+	assert.Error(t, err)
+}
+
+func TestMissingLabels(t *testing.T) {
+	for query, missing := range map[string][]string{
+		`up`:                         nil,
+		`sum(up)`:                    {"category", "product"},
+		`sum by (category) (up)`:     {"product"},
+		`sum without (product) (up)`: {"product"},
+		`label_replace(sum(up), "product", "p", "", "")`:                        {"category"},
+		`label_join(sum by (category) (up), "product", ":", "a", "b")`:          nil,
+		`sum_over_time(sum by (category, product) (up)[59m:1m])`:                nil,
+		`sum by (category) (up) * on(category) group_left(product) up`:          nil,
+		`sum by (category, product) (up) * on(category) sum by (category) (up)`: {"product"},
+		`sum by (category) (up) or sum by (product) (up)`:                       nil,
+		`sum by (category) (up) and sum by (product) (up)`:                      {"product"},
+		`vector(1) * 2`:                                            {"category", "product"},
+		`2 * sum by (category, product) (up)`:                      nil,
+		`count_values("product", sum by (category) (up))`:          {"category"},
+		`count_values by (category) ("product", up)`:               nil,
+		`sum without (product) (up) or sum by (product) (up)`:      nil,
+		`sum without (product) (up) or sum without (product) (up)`: {"product"},
+	} {
+		assert.Equal(t, missing, missingLabels(t, query), query)
+	}
+}
+
+func missingLabels(t *testing.T, query string) []string {
+	expr, err := promql.Parse(query)
+	require.NoError(t, err)
+	return promql.MissingLabels(expr, "category", "product")
+}
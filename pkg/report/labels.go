@@ -0,0 +1,62 @@
+package report
+
+import (
+	"fmt"
+
+	"github.com/prometheus/common/model"
+
+	"github.com/appuio/appuio-cloud-reporting/pkg/db"
+	"github.com/appuio/appuio-cloud-reporting/pkg/sourcekey"
+)
+
+// sampleKeys returns the source key and the category of the sample according to the label mapping of the query.
+func sampleKeys(query db.Query, m model.Metric) (sourcekey.SourceKey, string, error) {
+	labels := query.WithDefaults().QueryLabels
+
+	if !labels.Direct() {
+		category, err := getMetricLabel(m, labels.CategoryLabel)
+		if err != nil {
+			return sourcekey.SourceKey{}, "", err
+		}
+		productLabel, err := getMetricLabel(m, labels.ProductLabel)
+		if err != nil {
+			return sourcekey.SourceKey{}, "", err
+		}
+		skey, err := sourcekey.Parse(string(productLabel))
+		if err != nil {
+			return skey, "", fmt.Errorf("failed to parse source key from product label: %w", err)
+		}
+		return skey, string(category), nil
+	}
+
+	skey := sourcekey.SourceKey{
+		Query: query.Name,
+		Class: string(m[model.LabelName(labels.ClassLabel)]),
+	}
+	if product, ok := m[model.LabelName(labels.ProductLabel)]; ok {
+		skey.Query = string(product)
+	}
+	for _, l := range []struct {
+		name string
+		dst  *string
+	}{
+		{labels.ZoneLabel, &skey.Zone},
+		{labels.TenantLabel, &skey.Tenant},
+		{labels.NamespaceLabel, &skey.Namespace},
+	} {
+		if l.name == "" {
+			return skey, "", fmt.Errorf("zone, tenant and namespace labels must all be set to build the source key from labels")
+		}
+		value, err := getMetricLabel(m, l.name)
+		if err != nil {
+			return skey, "", err
+		}
+		*l.dst = string(value)
+	}
+
+	category := skey.Zone + ":" + skey.Namespace
+	if c, ok := m[model.LabelName(labels.CategoryLabel)]; ok {
+		category = string(c)
+	}
+	return skey, category, nil
+}
@@ -1,10 +1,24 @@
 package report
 
-import "time"
+import (
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/jmoiron/sqlx"
+	apiv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
 
 type options struct {
 	prometheusQueryTimeout time.Duration
 	progressReporter       progressReporter
+	sampleReporter         sampleReporter
+	unresolvedSamplePolicy UnresolvedSamplePolicy
+	parallelism            int
+	version                string
+	warningsPolicy         WarningsPolicy
+	logger                 logr.Logger
+	retryPolicy            RetryPolicy
+	failedRunsDB           *sqlx.DB
 }
 
 // Option represents a report option.
@@ -13,7 +27,7 @@ type Option interface {
 }
 
 func buildOptions(os []Option) options {
-	var build options
+	build := options{logger: logr.Discard()}
 	for _, o := range os {
 		o.set(&build)
 	}
@@ -48,3 +62,158 @@ type progressReporter func(Progress)
 func (t progressReporter) set(o *options) {
 	o.progressReporter = t
 }
+
+// ResolvedSample represents a sample of a query with the product and discount it was matched to.
+type ResolvedSample struct {
+	Timestamp time.Time
+	Query     string
+	SourceKey string
+	Category  string
+	Product   string
+	Discount  string
+	Quantity  float64
+	// Price is the quantity multiplied by the product amount with the discount applied.
+	Price float64
+}
+
+// WithSampleReporter allows setting a callback function.
+// The callback receives every sample after the product and discount were resolved.
+func WithSampleReporter(r func(ResolvedSample)) Option {
+	return sampleReporter(r)
+}
+
+type sampleReporter func(ResolvedSample)
+
+func (t sampleReporter) set(o *options) {
+	o.sampleReporter = t
+}
+
+// UnresolvedSamplePolicy defines how samples without a matching product or discount are handled.
+type UnresolvedSamplePolicy string
+
+const (
+	// FailOnUnresolvedSample aborts the report if a sample can't be resolved. This is the default.
+	FailOnUnresolvedSample UnresolvedSamplePolicy = "fail"
+	// SkipUnresolvedSample ignores samples which can't be resolved.
+	SkipUnresolvedSample UnresolvedSamplePolicy = "skip"
+	// QuarantineUnresolvedSample stores samples which can't be resolved in the quarantined_samples table.
+	// Quarantined samples can be replayed using ReplayQuarantine.
+	QuarantineUnresolvedSample UnresolvedSamplePolicy = "quarantine"
+)
+
+// UnresolvedSamplePolicies lists all valid unresolved sample policies.
+var UnresolvedSamplePolicies = []UnresolvedSamplePolicy{FailOnUnresolvedSample, SkipUnresolvedSample, QuarantineUnresolvedSample}
+
+// WithUnresolvedSamplePolicy allows setting how samples without a matching product or discount are handled.
+func WithUnresolvedSamplePolicy(p UnresolvedSamplePolicy) Option {
+	return p
+}
+
+func (p UnresolvedSamplePolicy) set(o *options) {
+	o.unresolvedSamplePolicy = p
+}
+
+// WithParallelism allows setting how many hours RunRange runs concurrently.
+// Callbacks other than the progress reporter may be called concurrently if the parallelism is greater than one.
+func WithParallelism(n int) Option {
+	return parallelism(n)
+}
+
+type parallelism int
+
+func (p parallelism) set(o *options) {
+	o.parallelism = int(p)
+}
+
+// WithFailedRunsDB allows recording failed runs of Run in the report_runs table.
+// Failed runs are recorded in the given database outside of the transaction of the report, which is expected to be rolled back.
+// RunRange and RunQueries always record failed runs.
+func WithFailedRunsDB(database *sqlx.DB) Option {
+	return failedRunsDB{database}
+}
+
+type failedRunsDB struct {
+	*sqlx.DB
+}
+
+func (d failedRunsDB) set(o *options) {
+	o.failedRunsDB = d.DB
+}
+
+// WithVersion allows setting the version of the reporting tool recorded with every report run.
+func WithVersion(v string) Option {
+	return version(v)
+}
+
+type version string
+
+func (v version) set(o *options) {
+	o.version = string(v)
+}
+
+// WarningsPolicy defines how warnings returned by prometheus are handled.
+// Warnings are always recorded on the report run.
+type WarningsPolicy string
+
+const (
+	// RecordWarnings only records warnings on the report run. This is the default.
+	RecordWarnings WarningsPolicy = "record"
+	// LogWarnings additionally logs warnings using the logger set by WithLogger.
+	LogWarnings WarningsPolicy = "log"
+	// FailOnWarnings fails the report if prometheus returns warnings.
+	FailOnWarnings WarningsPolicy = "fail"
+)
+
+// WarningsPolicies lists all valid warnings policies.
+var WarningsPolicies = []WarningsPolicy{RecordWarnings, LogWarnings, FailOnWarnings}
+
+// WithWarningsPolicy allows setting how warnings returned by prometheus are handled.
+func WithWarningsPolicy(p WarningsPolicy) Option {
+	return p
+}
+
+func (p WarningsPolicy) set(o *options) {
+	o.warningsPolicy = p
+}
+
+// WithLogger allows setting a logger. Nothing is logged if not set.
+func WithLogger(l logr.Logger) Option {
+	return logger{l}
+}
+
+type logger struct {
+	logr.Logger
+}
+
+func (l logger) set(o *options) {
+	o.logger = l.Logger
+}
+
+// ErrTransport is the error type of requests to prometheus that failed without a response, such as refused or reset connections.
+// Certificate, TLS and unknown host errors are never considered transport errors since retrying them won't help.
+const ErrTransport apiv1.ErrorType = "transport"
+
+// DefaultRetryableErrors are the prometheus error types retried if not configured otherwise.
+var DefaultRetryableErrors = []apiv1.ErrorType{apiv1.ErrTimeout, apiv1.ErrServer, ErrTransport}
+
+// RetryPolicy configures retries of failed prometheus queries.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts of a query. Values below two disable retries.
+	MaxAttempts int
+	// InitialBackoff is the time to wait before the first retry. It doubles with every retry.
+	InitialBackoff time.Duration
+	// MaxBackoff limits the time to wait between retries. Not limited if zero.
+	MaxBackoff time.Duration
+	// RetryableErrors lists the prometheus error types to retry.
+	// A query timing out because of WithPrometheusQueryTimeout counts as apiv1.ErrTimeout.
+	RetryableErrors []apiv1.ErrorType
+}
+
+// WithRetryPolicy allows retrying failed prometheus queries.
+func WithRetryPolicy(p RetryPolicy) Option {
+	return p
+}
+
+func (p RetryPolicy) set(o *options) {
+	o.retryPolicy = p
+}
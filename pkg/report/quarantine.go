@@ -0,0 +1,101 @@
+package report
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/prometheus/common/model"
+
+	"github.com/appuio/appuio-cloud-reporting/pkg/db"
+)
+
+// ReplayQuarantine processes all quarantined samples again.
+// Samples which can be resolved now are saved in the facts table and removed from the quarantine.
+// Samples which still can't be resolved stay in the quarantine.
+// Returns the number of replayed and the number of still unresolved samples.
+func ReplayQuarantine(ctx context.Context, tx *sqlx.Tx, options ...Option) (replayed int, unresolved int, err error) {
+	opts := buildOptions(options)
+
+	var quarantined []db.QuarantinedSample
+	if err := sqlx.SelectContext(ctx, tx, &quarantined, "SELECT * FROM quarantined_samples ORDER BY timestamp FOR UPDATE"); err != nil {
+		return 0, 0, fmt.Errorf("failed to load quarantined samples: %w", err)
+	}
+
+	queries := map[string]db.Query{}
+	for _, qs := range quarantined {
+		query, ok := queries[qs.QueryId]
+		if !ok {
+			if err := sqlx.GetContext(ctx, tx, &query, "SELECT * FROM queries WHERE id = $1", qs.QueryId); err != nil {
+				return replayed, unresolved, fmt.Errorf("failed to load query '%s': %w", qs.QueryId, err)
+			}
+			queries[qs.QueryId] = query
+		}
+
+		sample := &model.Sample{Value: model.SampleValue(qs.Value)}
+		if err := json.Unmarshal(qs.Labels, &sample.Metric); err != nil {
+			return replayed, unresolved, fmt.Errorf("failed to decode labels of quarantined sample '%s': %w", qs.Id, err)
+		}
+
+		err := processSample(ctx, tx, qs.Timestamp.In(time.UTC), query, sample, opts)
+		if errors.Is(err, ErrUnresolvedSample) {
+			unresolved++
+			if _, err := tx.ExecContext(ctx, "UPDATE quarantined_samples SET reason = $1 WHERE id = $2", err.Error(), qs.Id); err != nil {
+				return replayed, unresolved, fmt.Errorf("failed to update quarantined sample '%s': %w", qs.Id, err)
+			}
+			continue
+		} else if err != nil {
+			return replayed, unresolved, fmt.Errorf("failed to replay quarantined sample '%s': %w", qs.Id, err)
+		}
+
+		if _, err := tx.ExecContext(ctx, "DELETE FROM quarantined_samples WHERE id = $1", qs.Id); err != nil {
+			return replayed, unresolved, fmt.Errorf("failed to delete quarantined sample '%s': %w", qs.Id, err)
+		}
+		replayed++
+	}
+
+	return replayed, unresolved, nil
+}
+
+// handleUnresolvedSample handles a sample which could not be resolved according to the configured policy.
+// Returns the given error if the policy is to fail.
+func handleUnresolvedSample(ctx context.Context, tx *sqlx.Tx, ts time.Time, query db.Query, s *model.Sample, unresolvedErr error, opts options) error {
+	switch opts.unresolvedSamplePolicy {
+	case SkipUnresolvedSample:
+		return nil
+	case QuarantineUnresolvedSample:
+		labels, err := json.Marshal(s.Metric)
+		if err != nil {
+			return fmt.Errorf("failed to encode labels: %w", err)
+		}
+		var quarantined db.QuarantinedSample
+		return upsertQuarantinedSample(ctx, tx, &quarantined, db.QuarantinedSample{
+			QueryId:   query.Id,
+			Timestamp: ts,
+			Labels:    labels,
+			Value:     float64(s.Value),
+			Reason:    unresolvedErr.Error(),
+		})
+	default:
+		return unresolvedErr
+	}
+}
+
+func upsertQuarantinedSample(ctx context.Context, tx *sqlx.Tx, dst *db.QuarantinedSample, src db.QuarantinedSample) error {
+	err := db.GetNamedContext(ctx, tx, dst,
+		`INSERT INTO quarantined_samples
+				(query_id,timestamp,labels,value,reason)
+			VALUES
+				(:query_id,:timestamp,:labels,:value,:reason)
+			ON CONFLICT (query_id,timestamp,labels)
+				DO UPDATE SET value = :value, reason = :reason
+			RETURNING *`,
+		src)
+	if err != nil {
+		return fmt.Errorf("failed to upsert quarantined sample %+v: %w", src, err)
+	}
+	return nil
+}
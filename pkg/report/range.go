@@ -0,0 +1,92 @@
+package report
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	apiv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+
+	"github.com/appuio/appuio-cloud-reporting/pkg/db"
+)
+
+// querySamples runs the rendered promQL of the query in the mode of the query and returns a sample for every series.
+func querySamples(ctx context.Context, prom PromQuerier, query db.Query, promQL string, from time.Time, opts options) (model.Vector, apiv1.Warnings, error) {
+	switch query.Mode {
+	case db.QueryModeInstant, "":
+		// The data in the database is from T to T+1h. Prometheus queries backwards from T to T-1h.
+		value, warnings, err := queryWithRetry(ctx, func(ctx context.Context) (model.Value, apiv1.Warnings, error) {
+			return prom.Query(ctx, promQL, from.Add(time.Hour))
+		}, opts)
+		if err != nil {
+			return nil, warnings, err
+		}
+		samples, ok := value.(model.Vector)
+		if !ok {
+			return nil, warnings, fmt.Errorf("expected prometheus query to return a model.Vector, got %T", value)
+		}
+		return samples, warnings, nil
+	case db.QueryModeRange:
+		rangeProm, ok := prom.(PromRangeQuerier)
+		if !ok {
+			return nil, nil, fmt.Errorf("query '%s' is a range query but the prometheus client does not support range queries", query.Name)
+		}
+		step := query.RangeStep()
+		if step <= 0 {
+			return nil, nil, fmt.Errorf("invalid range step %s", step)
+		}
+		// Evaluate the same points in time as the instant subquery `[59m:1m]` at T+1h would.
+		r := apiv1.Range{Start: from.Add(step), End: from.Add(time.Hour), Step: step}
+		value, warnings, err := queryWithRetry(ctx, func(ctx context.Context) (model.Value, apiv1.Warnings, error) {
+			return rangeProm.QueryRange(ctx, promQL, r)
+		}, opts)
+		if err != nil {
+			return nil, warnings, err
+		}
+		matrix, ok := value.(model.Matrix)
+		if !ok {
+			return nil, warnings, fmt.Errorf("expected prometheus range query to return a model.Matrix, got %T", value)
+		}
+		samples, err := aggregateMatrix(matrix, query.RangeAggregation, r.End)
+		return samples, warnings, err
+	default:
+		return nil, nil, fmt.Errorf("unknown query mode '%s'", query.Mode)
+	}
+}
+
+// aggregateMatrix aggregates the samples of every series of the matrix into a single sample at the given timestamp.
+func aggregateMatrix(matrix model.Matrix, aggregation string, ts time.Time) (model.Vector, error) {
+	samples := make(model.Vector, 0, len(matrix))
+	for _, series := range matrix {
+		if len(series.Values) == 0 {
+			continue
+		}
+		var value float64
+		switch aggregation {
+		case db.RangeAggregationAvg:
+			for _, p := range series.Values {
+				value += float64(p.Value)
+			}
+			value /= float64(len(series.Values))
+		case db.RangeAggregationMax:
+			value = math.Inf(-1)
+			for _, p := range series.Values {
+				value = math.Max(value, float64(p.Value))
+			}
+		case db.RangeAggregationSum:
+			for _, p := range series.Values {
+				value += float64(p.Value)
+			}
+		default:
+			return nil, fmt.Errorf("unknown range aggregation '%s'", aggregation)
+		}
+		samples = append(samples, &model.Sample{
+			Metric:    series.Metric,
+			Value:     model.SampleValue(value),
+			Timestamp: model.TimeFromUnixNano(ts.UnixNano()),
+		})
+	}
+	return samples, nil
+}
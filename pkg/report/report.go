@@ -2,97 +2,316 @@ package report
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/appuio/appuio-cloud-reporting/pkg/db"
-	"github.com/appuio/appuio-cloud-reporting/pkg/sourcekey"
+	"github.com/appuio/appuio-cloud-reporting/pkg/promql"
 	"github.com/jackc/pgx/v4"
 	"github.com/jmoiron/sqlx"
 	apiv1 "github.com/prometheus/client_golang/api/prometheus/v1"
 	"github.com/prometheus/common/model"
 )
 
+// ErrUnresolvedSample is returned if no product or discount matches the source key of a sample.
+var ErrUnresolvedSample = errors.New("unresolved sample")
+
+// ErrPrometheusWarnings is returned if prometheus returned warnings and the warnings policy is to fail.
+var ErrPrometheusWarnings = errors.New("prometheus returned warnings")
+
 type PromQuerier interface {
 	Query(ctx context.Context, query string, ts time.Time) (model.Value, apiv1.Warnings, error)
 }
 
-// RunRange executes prometheus queries like Run() until the `until` timestamp is reached or an error occurred.
+// PromRangeQuerier is a PromQuerier which additionally supports range queries.
+// Queries in the range mode can only be run with a PromRangeQuerier.
+type PromRangeQuerier interface {
+	PromQuerier
+	QueryRange(ctx context.Context, query string, r apiv1.Range) (model.Value, apiv1.Warnings, error)
+}
+
+// RunRange executes prometheus queries like Run() until the `until` timestamp is reached.
+// Every hour is run in its own transaction. Hours are run concurrently if WithParallelism is set.
+// A failed hour does not stop the other hours from running. If any hours failed a *RangeError listing all of them is returned.
 // Returns the number of reports run and a possible error.
 func RunRange(ctx context.Context, database *sqlx.DB, prom PromQuerier, queryName string, from time.Time, until time.Time, options ...Option) (int, error) {
+	summaries, err := RunQueries(ctx, database, prom, []string{queryName}, from, until, options...)
+	if len(summaries) == 0 {
+		return 0, err
+	}
+	if len(summaries[0].Failed) > 0 {
+		return summaries[0].Reports, &RangeError{Failed: summaries[0].Failed}
+	}
+	return summaries[0].Reports, err
+}
+
+// QuerySummary summarizes the reports of a single query run by RunQueries.
+type QuerySummary struct {
+	Query string
+	// Reports is the number of hours the query was run for.
+	Reports int
+	// Samples is the number of samples returned by the query and its sub-queries in all successful reports.
+	Samples int
+	// Failed holds the errors of all failed hours ordered by time.
+	Failed []HourError
+}
+
+type reportJob struct {
+	queryName string
+	ts        time.Time
+}
+
+// RunQueries executes the given prometheus queries like Run() until the `until` timestamp is reached.
+// If no query names are given, all top-level queries valid at an hour are run for that hour.
+// Every query and hour is run in its own transaction. Reports are run concurrently if WithParallelism is set.
+// A failed report does not stop the other reports from running.
+// Returns a summary for every query ordered by query name.
+// The error is only set if the queries could not be loaded or the context was cancelled.
+func RunQueries(ctx context.Context, database *sqlx.DB, prom PromQuerier, queryNames []string, from time.Time, until time.Time, options ...Option) ([]QuerySummary, error) {
 	opts := buildOptions(options)
+	parallelism := opts.parallelism
+	if parallelism < 1 {
+		parallelism = 1
+	}
 
+	var mu sync.Mutex
 	n := 0
+	summaries := map[string]*QuerySummary{}
+	summary := func(name string) *QuerySummary {
+		if _, ok := summaries[name]; !ok {
+			summaries[name] = &QuerySummary{Query: name}
+		}
+		return summaries[name]
+	}
+	for _, name := range queryNames {
+		summary(name)
+	}
+
+	jobs := make(chan reportJob)
+	var wg sync.WaitGroup
+	for i := 0; i < parallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				mu.Lock()
+				n++
+				summary(j.queryName).Reports++
+				if opts.progressReporter != nil {
+					opts.progressReporter(Progress{j.ts, n})
+				}
+				mu.Unlock()
+
+				started := time.Now()
+				var res runResult
+				err := runInTransaction(ctx, database, func(tx *sqlx.Tx) error {
+					var err error
+					if res, err = run(ctx, tx, prom, j.queryName, j.ts, opts); err != nil {
+						return err
+					}
+					return recordRun(ctx, tx, newReportRun(j.queryName, j.ts, started, res, nil, opts))
+				})
+				if err != nil {
+					// The transaction was rolled back, the failed run is recorded outside of it.
+					err = recordFailedRun(ctx, database, newReportRun(j.queryName, j.ts, started, res, err, opts), err)
+				}
+
+				mu.Lock()
+				if err != nil {
+					summary(j.queryName).Failed = append(summary(j.queryName).Failed, HourError{Timestamp: j.ts, Err: err})
+				} else {
+					summary(j.queryName).Samples += res.samples
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	var dispatchErr error
+dispatch:
 	for currentTime := from; until.After(currentTime); currentTime = currentTime.Add(time.Hour) {
-		n++
-		if opts.progressReporter != nil {
-			opts.progressReporter(Progress{currentTime, n})
+		names := queryNames
+		if len(names) == 0 {
+			names, dispatchErr = QueryNamesAt(ctx, database, currentTime)
+			if dispatchErr != nil {
+				break
+			}
+		}
+		for _, name := range names {
+			select {
+			case jobs <- reportJob{queryName: name, ts: currentTime}:
+			case <-ctx.Done():
+				break dispatch
+			}
 		}
-		if err := db.RunInTransaction(ctx, database, func(tx *sqlx.Tx) error {
-			return Run(ctx, tx, prom, queryName, currentTime, options...)
-		}); err != nil {
-			return n, fmt.Errorf("error running report at %s: %w", currentTime.Format(time.RFC3339), err)
+	}
+	close(jobs)
+	wg.Wait()
+
+	result := make([]QuerySummary, 0, len(summaries))
+	for _, s := range summaries {
+		sort.Slice(s.Failed, func(i, j int) bool { return s.Failed[i].Timestamp.Before(s.Failed[j].Timestamp) })
+		result = append(result, *s)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Query < result[j].Query })
+
+	if dispatchErr == nil {
+		dispatchErr = ctx.Err()
+	}
+	return result, dispatchErr
+}
+
+// transactionAttempts is the number of times the transaction of a report is run if it conflicts with concurrent reports.
+const transactionAttempts = 3
+
+// runInTransaction runs cb in a transaction like db.RunInTransaction and retries it if it conflicted with a concurrent transaction.
+// Concurrent reports can deadlock while inserting the same new tenants and categories in a different order.
+func runInTransaction(ctx context.Context, database *sqlx.DB, cb func(tx *sqlx.Tx) error) error {
+	var err error
+	for i := 0; i < transactionAttempts; i++ {
+		if err = db.RunInTransaction(ctx, database, cb); !db.IsTransactionConflict(err) {
+			return err
 		}
 	}
+	return err
+}
+
+// QueryNamesAt returns the names of all top-level queries valid at the given timestamp ordered by name.
+func QueryNamesAt(ctx context.Context, q sqlx.QueryerContext, ts time.Time) ([]string, error) {
+	var names []string
+	if err := sqlx.SelectContext(ctx, q, &names,
+		"SELECT name FROM queries WHERE parent_id IS NULL AND during @> $1::timestamptz ORDER BY name", ts,
+	); err != nil {
+		return nil, fmt.Errorf("failed to load queries at '%s': %w", ts.Format(time.RFC3339), err)
+	}
+	return names, nil
+}
+
+// HourError is the error of a single failed hour of RunRange or RunQueries.
+type HourError struct {
+	Timestamp time.Time
+	Err       error
+}
+
+func (e HourError) Error() string {
+	return fmt.Sprintf("error running report at %s: %s", e.Timestamp.Format(time.RFC3339), e.Err)
+}
+
+// RangeError is returned by RunRange if one or more hours failed.
+type RangeError struct {
+	// Failed holds the errors of all failed hours ordered by time.
+	Failed []HourError
+}
+
+func (e *RangeError) Error() string {
+	msgs := make([]string, len(e.Failed))
+	for i, f := range e.Failed {
+		msgs[i] = f.Error()
+	}
+	return fmt.Sprintf("%d reports failed: %s", len(e.Failed), strings.Join(msgs, "; "))
+}
 
-	return n, nil
+// Unwrap returns the error of the first failed hour.
+func (e *RangeError) Unwrap() error {
+	if len(e.Failed) == 0 {
+		return nil
+	}
+	return e.Failed[0].Err
 }
 
 // Run executes a prometheus query loaded from queries with using the `queryName` and the timestamp.
 // The results of the query are saved in the facts table.
+// A successful run is recorded in the report_runs table.
+// Failed runs are recorded outside of the transaction, which is expected to be rolled back, if WithFailedRunsDB is set.
 func Run(ctx context.Context, tx *sqlx.Tx, prom PromQuerier, queryName string, from time.Time, options ...Option) error {
 	opts := buildOptions(options)
+	started := time.Now()
+	res, err := run(ctx, tx, prom, queryName, from, opts)
+	if err != nil {
+		if opts.failedRunsDB != nil {
+			err = recordFailedRun(ctx, opts.failedRunsDB, newReportRun(queryName, from, started, res, err, opts), err)
+		}
+		return err
+	}
+	return recordRun(ctx, tx, newReportRun(queryName, from, started, res, nil, opts))
+}
+
+// runResult holds the samples count and warnings of a run.
+type runResult struct {
+	samples  int
+	warnings []string
+}
+
+// run executes the query and its sub-queries.
+func run(ctx context.Context, tx *sqlx.Tx, prom PromQuerier, queryName string, from time.Time, opts options) (runResult, error) {
+	var res runResult
 
 	from = from.In(time.UTC)
 	if !from.Truncate(time.Hour).Equal(from) {
-		return fmt.Errorf("timestamp should only contain full hours based on UTC, got: %s", from.Format(time.RFC3339Nano))
+		return res, fmt.Errorf("timestamp should only contain full hours based on UTC, got: %s", from.Format(time.RFC3339Nano))
 	}
 
 	var query db.Query
 	if err := sqlx.GetContext(ctx, tx, &query, "SELECT * FROM queries WHERE name = $1 AND (during @> $2::timestamptz)", queryName, from); err != nil {
-		return fmt.Errorf("failed to load query '%s' at '%s': %w", queryName, from.Format(time.RFC3339), err)
+		return res, fmt.Errorf("failed to load query '%s' at '%s': %w", queryName, from.Format(time.RFC3339), err)
 	}
 
-	if err := runQuery(ctx, tx, prom, query, from, opts); err != nil {
-		return fmt.Errorf("failed to run query '%s' at '%s': %w", queryName, from.Format(time.RFC3339), err)
+	params, err := db.QueryParametersAt(ctx, tx, from)
+	if err != nil {
+		return res, fmt.Errorf("failed to load query parameters at '%s': %w", from.Format(time.RFC3339), err)
+	}
+
+	if err := runQuery(ctx, tx, prom, query, from, params, opts, &res); err != nil {
+		return res, fmt.Errorf("failed to run query '%s' at '%s': %w", queryName, from.Format(time.RFC3339), err)
 	}
 
 	var subQueries []db.Query
 	if err := sqlx.SelectContext(ctx, tx, &subQueries,
-		"SELECT id, name, description, query, unit, during FROM queries WHERE parent_id  = $1 AND (during @> $2::timestamptz)", query.Id, from,
+		"SELECT * FROM queries WHERE parent_id  = $1 AND (during @> $2::timestamptz)", query.Id, from,
 	); err != nil {
-		return fmt.Errorf("failed to load subQueries for '%s' at '%s': %w", queryName, from.Format(time.RFC3339), err)
+		return res, fmt.Errorf("failed to load subQueries for '%s' at '%s': %w", queryName, from.Format(time.RFC3339), err)
 	}
 	for _, subQuery := range subQueries {
-		if err := runQuery(ctx, tx, prom, subQuery, from, opts); err != nil {
-			return fmt.Errorf("failed to run subQuery '%s' at '%s': %w", subQuery.Name, from.Format(time.RFC3339), err)
+		if err := runQuery(ctx, tx, prom, subQuery, from, params, opts, &res); err != nil {
+			return res, fmt.Errorf("failed to run subQuery '%s' at '%s': %w", subQuery.Name, from.Format(time.RFC3339), err)
 		}
 	}
 
-	return nil
+	return res, nil
 }
 
-func runQuery(ctx context.Context, tx *sqlx.Tx, prom PromQuerier, query db.Query, from time.Time, opts options) error {
-	promQCtx := ctx
-	if opts.prometheusQueryTimeout != 0 {
-		ctx, cancel := context.WithTimeout(promQCtx, opts.prometheusQueryTimeout)
-		defer cancel()
-		promQCtx = ctx
+func runQuery(ctx context.Context, tx *sqlx.Tx, prom PromQuerier, query db.Query, from time.Time, params map[string]string, opts options, res *runResult) error {
+	rendered, err := promql.Render(query.Name, query.Query, params)
+	if err != nil {
+		return err
 	}
-	// The data in the database is from T to T+1h. Prometheus queries backwards from T to T-1h.
-	res, _, err := prom.Query(promQCtx, query.Query, from.Add(time.Hour))
+	samples, warnings, err := querySamples(ctx, prom, query, rendered, from, opts)
 	if err != nil {
 		return fmt.Errorf("failed to query prometheus: %w", err)
 	}
-
-	samples, ok := res.(model.Vector)
-	if !ok {
-		return fmt.Errorf("expected prometheus query to return a model.Vector, got %T", res)
+	res.warnings = append(res.warnings, warnings...)
+	if len(warnings) > 0 {
+		switch opts.warningsPolicy {
+		case LogWarnings:
+			opts.logger.Info("Prometheus returned warnings", "query", query.Name, "timestamp", from.Format(time.RFC3339), "warnings", []string(warnings))
+		case FailOnWarnings:
+			return fmt.Errorf("%w: %s", ErrPrometheusWarnings, strings.Join(warnings, "; "))
+		}
 	}
 
+	res.samples += len(samples)
+
 	for _, sample := range samples {
-		if err := processSample(ctx, tx, from, query, sample); err != nil {
+		err := processSample(ctx, tx, from, query, sample, opts)
+		if errors.Is(err, ErrUnresolvedSample) {
+			err = handleUnresolvedSample(ctx, tx, from, query, sample, err, opts)
+		}
+		if err != nil {
 			return fmt.Errorf("failed to process sample: %w", err)
 		}
 	}
@@ -100,43 +319,39 @@ func runQuery(ctx context.Context, tx *sqlx.Tx, prom PromQuerier, query db.Query
 	return nil
 }
 
-func processSample(ctx context.Context, tx *sqlx.Tx, ts time.Time, query db.Query, s *model.Sample) error {
-	category, err := getMetricLabel(s.Metric, "category")
+func processSample(ctx context.Context, tx *sqlx.Tx, ts time.Time, query db.Query, s *model.Sample, opts options) error {
+	skey, category, err := sampleKeys(query, s.Metric)
 	if err != nil {
 		return err
 	}
-	productLabel, err := getMetricLabel(s.Metric, "product")
-	if err != nil {
-		return err
+
+	sourceLookup := skey.LookupKeys()
+	sourceKey := skey.String()
+
+	var product db.Product
+	if err := getBySourceKeyAndTime(ctx, tx, &product, pgx.Identifier{"products"}, sourceLookup, ts); errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w: no product found for '%s'", ErrUnresolvedSample, sourceKey)
+	} else if err != nil {
+		return fmt.Errorf("failed to load product for '%s': %w", sourceKey, err)
 	}
 
-	skey, err := sourcekey.Parse(string(productLabel))
-	if err != nil {
-		return fmt.Errorf("failed to parse source key from product label: %w", err)
+	var discount db.Discount
+	if err := getBySourceKeyAndTime(ctx, tx, &discount, pgx.Identifier{"discounts"}, sourceLookup, ts); errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w: no discount found for '%s'", ErrUnresolvedSample, sourceKey)
+	} else if err != nil {
+		return fmt.Errorf("failed to load discount for '%s': %w", sourceKey, err)
 	}
 
 	var upsertedTenant db.Tenant
-	if upsertTenant(ctx, tx, &upsertedTenant, db.Tenant{Source: skey.Tenant}); err != nil {
+	if err := upsertTenant(ctx, tx, &upsertedTenant, db.Tenant{Source: skey.Tenant}); err != nil {
 		return err
 	}
 
 	var upsertedCategory db.Category
-	if err := upsertCategory(ctx, tx, &upsertedCategory, db.Category{Source: string(category)}); err != nil {
+	if err := upsertCategory(ctx, tx, &upsertedCategory, db.Category{Source: category}); err != nil {
 		return err
 	}
 
-	sourceLookup := skey.LookupKeys()
-
-	var product db.Product
-	if err := getBySourceKeyAndTime(ctx, tx, &product, pgx.Identifier{"products"}, sourceLookup, ts); err != nil {
-		return fmt.Errorf("failed to load product for '%s': %w", productLabel, err)
-	}
-
-	var discount db.Discount
-	if err := getBySourceKeyAndTime(ctx, tx, &discount, pgx.Identifier{"discounts"}, sourceLookup, ts); err != nil {
-		return fmt.Errorf("failed to load discount for '%s': %w", productLabel, err)
-	}
-
 	var upsertedDateTime db.DateTime
 	err = upsertDateTime(ctx, tx, &upsertedDateTime, db.BuildDateTime(ts))
 	if err != nil {
@@ -157,6 +372,19 @@ func processSample(ctx context.Context, tx *sqlx.Tx, ts time.Time, query db.Quer
 		return fmt.Errorf("failed to upsert fact '%s': %w", ts.Format(time.RFC3339), err)
 	}
 
+	if opts.sampleReporter != nil {
+		opts.sampleReporter(ResolvedSample{
+			Timestamp: ts,
+			Query:     query.Name,
+			SourceKey: sourceKey,
+			Category:  category,
+			Product:   product.Source,
+			Discount:  discount.Source,
+			Quantity:  upsertedFact.Quantity,
+			Price:     upsertedFact.Quantity * product.Amount * (1 - discount.Discount),
+		})
+	}
+
 	return nil
 }
 
@@ -197,17 +425,9 @@ func upsertFact(ctx context.Context, tx *sqlx.Tx, dst *db.Fact, src db.Fact) err
 }
 
 func upsertCategory(ctx context.Context, tx *sqlx.Tx, dst *db.Category, src db.Category) error {
-	err := db.GetNamedContext(ctx, tx, dst,
-		`WITH
-				existing AS (
-					SELECT * FROM categories WHERE source = :source
-				),
-				inserted AS (
-					INSERT INTO categories (source)
-					SELECT :source WHERE NOT EXISTS (SELECT 1 FROM existing)
-					RETURNING *
-				)
-			SELECT * FROM inserted UNION ALL SELECT * FROM existing`,
+	err := insertOrGet(ctx, tx, dst,
+		"INSERT INTO categories (source) VALUES (:source) ON CONFLICT (source) DO NOTHING",
+		"SELECT * FROM categories WHERE source = :source",
 		src)
 	if err != nil {
 		return fmt.Errorf("failed to upsert category %+v: %w", src, err)
@@ -216,17 +436,9 @@ func upsertCategory(ctx context.Context, tx *sqlx.Tx, dst *db.Category, src db.C
 }
 
 func upsertTenant(ctx context.Context, tx *sqlx.Tx, dst *db.Tenant, src db.Tenant) error {
-	err := db.GetNamedContext(ctx, tx, dst,
-		`WITH
-				existing AS (
-					SELECT * FROM tenants WHERE source = :source
-				),
-				inserted AS (
-					INSERT INTO tenants (source)
-					SELECT :source WHERE NOT EXISTS (SELECT 1 FROM existing)
-					RETURNING *
-				)
-			SELECT * FROM inserted UNION ALL SELECT * FROM existing`,
+	err := insertOrGet(ctx, tx, dst,
+		"INSERT INTO tenants (source) VALUES (:source) ON CONFLICT (source) DO NOTHING",
+		"SELECT * FROM tenants WHERE source = :source",
 		src)
 	if err != nil {
 		return fmt.Errorf("failed to upsert tenant %+v: %w", src, err)
@@ -235,17 +447,11 @@ func upsertTenant(ctx context.Context, tx *sqlx.Tx, dst *db.Tenant, src db.Tenan
 }
 
 func upsertDateTime(ctx context.Context, tx *sqlx.Tx, dst *db.DateTime, src db.DateTime) error {
-	err := db.GetNamedContext(ctx, tx, dst,
-		`WITH
-		existing AS (
-			SELECT * FROM date_times WHERE year = :year AND month = :month AND day = :day AND hour = :hour
-		),
-		inserted AS (
-			INSERT INTO date_times (timestamp, year, month, day, hour)
-			SELECT :timestamp, :year, :month, :day, :hour WHERE NOT EXISTS (SELECT 1 FROM existing)
-			RETURNING *
-		)
-		SELECT * FROM inserted UNION ALL SELECT * FROM existing`,
+	err := insertOrGet(ctx, tx, dst,
+		`INSERT INTO date_times (timestamp, year, month, day, hour)
+			VALUES (:timestamp, :year, :month, :day, :hour)
+			ON CONFLICT (year, month, day, hour) DO NOTHING`,
+		"SELECT * FROM date_times WHERE year = :year AND month = :month AND day = :day AND hour = :hour",
 		src)
 	if err != nil {
 		return fmt.Errorf("failed to upsert date_time %+v: %w", src, err)
@@ -253,6 +459,17 @@ func upsertDateTime(ctx context.Context, tx *sqlx.Tx, dst *db.DateTime, src db.D
 	return nil
 }
 
+// insertOrGet runs the insert, which must ignore conflicts, and then loads the inserted or existing row with get.
+// Concurrent reports insert the same tenants, categories and hours.
+// If another transaction inserted the row first, the insert waits for it to commit and does nothing.
+// The row is then loaded by a separate statement, since only a new statement sees rows committed after the insert started.
+func insertOrGet(ctx context.Context, tx *sqlx.Tx, dst interface{}, insert, get string, src interface{}) error {
+	if _, err := tx.NamedExecContext(ctx, insert, src); err != nil {
+		return err
+	}
+	return db.GetNamedContext(ctx, tx, dst, get, src)
+}
+
 func getMetricLabel(m model.Metric, name string) (model.LabelValue, error) {
 	value, ok := m[model.LabelName(name)]
 	if !ok {
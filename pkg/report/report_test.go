@@ -4,11 +4,19 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/jackc/pgtype"
 	"github.com/jmoiron/sqlx"
+	"github.com/prometheus/client_golang/api"
+	apiv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 
@@ -126,6 +134,219 @@ func (s *ReportSuite) TestReport_RunRange() {
 	require.Equal(t, hoursToCalculate, factCount)
 }
 
+func (s *ReportSuite) TestReport_RunRangeParallel() {
+	t := s.T()
+	prom := s.PrometheusAPIClient()
+	query := s.sampleQuery
+	tdb := s.DB()
+
+	const hoursToCalculate = 6
+
+	defer tdb.Exec("DELETE FROM facts")
+
+	base := time.Date(2020, time.January, 23, 17, 0, 0, 0, time.UTC)
+
+	var mu sync.Mutex
+	counts := make([]int, 0)
+	c, err := report.RunRange(context.Background(), tdb, prom, query.Name, base, base.Add(hoursToCalculate*time.Hour),
+		report.WithParallelism(3),
+		report.WithProgressReporter(func(p report.Progress) {
+			mu.Lock()
+			defer mu.Unlock()
+			counts = append(counts, p.Count)
+		}),
+	)
+	require.NoError(t, err)
+	require.Equal(t, hoursToCalculate, c)
+	require.ElementsMatch(t, []int{1, 2, 3, 4, 5, 6}, counts)
+
+	var factCount int
+	require.NoError(t, sqlx.Get(tdb, &factCount, "SELECT COUNT(*) FROM facts"))
+	require.Equal(t, hoursToCalculate, factCount)
+}
+
+func (s *ReportSuite) TestReport_RunRangeParallelNewTenants() {
+	t := s.T()
+	prom := s.PrometheusAPIClient()
+	tdb := s.DB()
+
+	query := s.createNewTenantQuery("test_new_tenant", "new-tenant", "new-namespace")
+	defer s.cleanupNewTenant("new-tenant", "new-namespace", query)
+
+	const hoursToCalculate = 8
+	base := time.Date(2020, time.February, 3, 0, 0, 0, 0, time.UTC)
+
+	c, err := report.RunRange(context.Background(), tdb, prom, query.Name, base, base.Add(hoursToCalculate*time.Hour),
+		report.WithParallelism(4),
+	)
+	require.NoError(t, err, "concurrent hours should not fail inserting the same new tenant, category and hour")
+	require.Equal(t, hoursToCalculate, c)
+
+	requireCount(t, tdb, 1, "SELECT COUNT(*) FROM tenants WHERE source = 'new-tenant'")
+	requireCount(t, tdb, 1, "SELECT COUNT(*) FROM categories WHERE source = 'my-cluster:new-namespace'")
+	requireCount(t, tdb, hoursToCalculate, "SELECT COUNT(*) FROM facts WHERE query_id = $1", query.Id)
+}
+
+func (s *ReportSuite) TestReport_RunRangeListsAllFailedHours() {
+	t := s.T()
+	prom := s.PrometheusAPIClient()
+
+	base := time.Date(2020, time.January, 23, 17, 0, 0, 0, time.UTC)
+
+	c, err := report.RunRange(context.Background(), s.DB(), prom, "missing-query", base, base.Add(3*time.Hour),
+		report.WithParallelism(2),
+	)
+	require.Equal(t, 3, c)
+	var rangeErr *report.RangeError
+	require.ErrorAs(t, err, &rangeErr)
+	require.Len(t, rangeErr.Failed, 3)
+	for i, f := range rangeErr.Failed {
+		require.Equal(t, base.Add(time.Duration(i)*time.Hour), f.Timestamp)
+	}
+	requireCount(t, s.DB(), 3, "SELECT COUNT(*) FROM report_runs WHERE query_name = 'missing-query' AND status = 'failed' AND error != ''")
+}
+
+func (s *ReportSuite) TestReport_RunRecordsFailedRuns() {
+	t := s.T()
+	prom := s.PrometheusAPIClient()
+	tdb := s.DB()
+	defer tdb.Exec("DELETE FROM report_runs WHERE query_name = 'missing-run-query'")
+
+	ts := time.Date(2020, time.January, 23, 17, 0, 0, 0, time.UTC)
+	failed := "SELECT COUNT(*) FROM report_runs WHERE query_name = 'missing-run-query' AND status = 'failed' AND error != ''"
+
+	tx, err := tdb.Beginx()
+	require.NoError(t, err)
+	require.Error(t, report.Run(context.Background(), tx, prom, "missing-run-query", ts))
+	require.NoError(t, tx.Rollback())
+	requireCount(t, tdb, 0, failed)
+
+	tx, err = tdb.Beginx()
+	require.NoError(t, err)
+	require.Error(t, report.Run(context.Background(), tx, prom, "missing-run-query", ts, report.WithFailedRunsDB(tdb)))
+	require.NoError(t, tx.Rollback())
+	requireCount(t, tdb, 1, failed)
+}
+
+func (s *ReportSuite) TestReport_Status() {
+	t := s.T()
+	prom := s.PrometheusAPIClient()
+	query := s.sampleQuery
+	tdb := s.DB()
+
+	defer tdb.Exec("DELETE FROM facts")
+	defer tdb.Exec("DELETE FROM report_runs")
+
+	base := time.Date(2021, time.March, 1, 0, 0, 0, 0, time.UTC)
+
+	_, err := report.RunRange(context.Background(), tdb, prom, query.Name, base, base.Add(2*time.Hour), report.WithVersion("v1.2.3"))
+	require.NoError(t, err)
+
+	var run db.ReportRun
+	require.NoError(t, sqlx.Get(tdb, &run, "SELECT * FROM report_runs WHERE query_name = $1 AND timestamp = $2", query.Name, base))
+	require.Equal(t, report.RunSucceeded, run.Status)
+	require.Equal(t, 1, run.Samples)
+	require.Equal(t, "v1.2.3", run.Version)
+
+	statuses, err := report.Status(context.Background(), tdb, base, base.Add(3*time.Hour))
+	require.NoError(t, err)
+	var status report.QueryStatus
+	for _, st := range statuses {
+		if st.Query == query.Name {
+			status = st
+		}
+	}
+	require.Equal(t, []report.HourStatus{
+		{Timestamp: base, Status: report.RunSucceeded},
+		{Timestamp: base.Add(time.Hour), Status: report.RunSucceeded},
+		{Timestamp: base.Add(2 * time.Hour), Status: report.RunMissing},
+	}, status.Hours)
+	require.Equal(t, 2, status.Count(report.RunSucceeded))
+}
+
+func (s *ReportSuite) TestReport_RunQueriesSummary() {
+	t := s.T()
+	prom := s.PrometheusAPIClient()
+	query := s.sampleQuery
+	tdb := s.DB()
+
+	defer tdb.Exec("DELETE FROM facts")
+
+	base := time.Date(2020, time.January, 23, 17, 0, 0, 0, time.UTC)
+
+	summaries, err := report.RunQueries(context.Background(), tdb, prom, []string{query.Name, "does-not-exist"}, base, base.Add(2*time.Hour),
+		report.WithParallelism(2),
+	)
+	require.NoError(t, err)
+	require.Len(t, summaries, 2)
+
+	require.Equal(t, "does-not-exist", summaries[0].Query)
+	require.Equal(t, 2, summaries[0].Reports)
+	require.Len(t, summaries[0].Failed, 2)
+
+	require.Equal(t, query.Name, summaries[1].Query)
+	require.Equal(t, 2, summaries[1].Reports)
+	require.Equal(t, 2, summaries[1].Samples)
+	require.Empty(t, summaries[1].Failed)
+}
+
+func (s *ReportSuite) TestReport_RunQueriesAllQueriesNewHour() {
+	t := s.T()
+	prom := s.PrometheusAPIClient()
+	tdb := s.DB()
+
+	first := s.createNewTenantQuery("test_new_tenant_first", "new-tenant", "new-namespace")
+	second := s.createNewTenantQuery("test_new_tenant_second", "new-tenant", "new-namespace")
+	defer s.cleanupNewTenant("new-tenant", "new-namespace", first, second)
+	defer tdb.Exec("DELETE FROM facts")
+
+	ts := time.Date(2020, time.February, 10, 5, 0, 0, 0, time.UTC)
+	summaries, err := report.RunQueries(context.Background(), tdb, prom, nil, ts, ts.Add(time.Hour),
+		report.WithParallelism(3),
+	)
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, len(summaries), 3)
+	for _, summary := range summaries {
+		require.Empty(t, summary.Failed, "all queries of the same new hour should be reported concurrently")
+	}
+	requireCount(t, tdb, 1, "SELECT COUNT(*) FROM date_times WHERE timestamp = $1", ts)
+}
+
+func (s *ReportSuite) TestReport_QueryNamesAt() {
+	t := s.T()
+
+	tx, err := s.DB().Beginx()
+	require.NoError(t, err)
+	defer tx.Rollback()
+
+	base := time.Date(2020, time.January, 23, 17, 0, 0, 0, time.UTC)
+	_, err = db.CreateQuery(tx, db.Query{
+		Name:   "expired",
+		Query:  "vector(1)",
+		Unit:   "tps",
+		During: db.Timerange(db.MustTimestamp(pgtype.NegativeInfinity), db.MustTimestamp(base)),
+	})
+	require.NoError(t, err)
+	_, err = db.CreateQuery(tx, db.Query{
+		ParentID: sql.NullString{String: s.sampleQuery.Id, Valid: true},
+		Name:     "sub",
+		Query:    "vector(1)",
+		Unit:     "tps",
+		During:   infiniteRange(),
+	})
+	require.NoError(t, err)
+
+	names, err := report.QueryNamesAt(context.Background(), tx, base.Add(-time.Hour))
+	require.NoError(t, err)
+	require.Contains(t, names, "expired")
+	require.Contains(t, names, s.sampleQuery.Name)
+	require.NotContains(t, names, "sub")
+
+	names, err = report.QueryNamesAt(context.Background(), tx, base)
+	require.NoError(t, err)
+	require.NotContains(t, names, "expired")
+}
+
 func (s *ReportSuite) TestReport_RunReportCreatesFact() {
 	t := s.T()
 	prom := s.PrometheusAPIClient()
@@ -141,6 +362,99 @@ func (s *ReportSuite) TestReport_RunReportCreatesFact() {
 	require.Equal(t, float64(defaultQueryReturnValue), fact.Quantity)
 }
 
+func (s *ReportSuite) TestReport_RunReportReportsResolvedSamples() {
+	t := s.T()
+	prom := s.PrometheusAPIClient()
+	query := s.sampleQuery
+
+	tx, err := s.DB().Beginx()
+	require.NoError(t, err)
+	defer tx.Rollback()
+
+	ts := time.Now().Truncate(time.Hour).In(time.UTC)
+	samples := make([]report.ResolvedSample, 0)
+	require.NoError(t, report.Run(context.Background(), tx, prom, query.Name, ts,
+		report.WithSampleReporter(func(s report.ResolvedSample) { samples = append(samples, s) }),
+	))
+	require.Equal(t, []report.ResolvedSample{{
+		Timestamp: ts,
+		Query:     query.Name,
+		SourceKey: "my-product:my-cluster:my-tenant:my-namespace",
+		Category:  "my-cluster:my-namespace",
+		Product:   "my-product:my-cluster",
+		Discount:  "my-product:my-cluster",
+		Quantity:  defaultQueryReturnValue,
+		Price:     defaultQueryReturnValue * s.sampleProduct.Amount * (1 - s.sampleDiscount.Discount),
+	}}, samples)
+}
+
+func (s *ReportSuite) TestReport_LabelMapping() {
+	t := s.T()
+	prom := s.PrometheusAPIClient()
+
+	tx, err := s.DB().Beginx()
+	require.NoError(t, err)
+	defer tx.Rollback()
+
+	query, err := db.CreateQuery(tx, db.Query{
+		Name: "my-product",
+		Query: `label_replace(label_replace(label_replace(label_replace(vector(5),
+			"cluster_id", "my-cluster", "", ""), "tenant_id", "my-tenant", "", ""), "namespace", "my-namespace", "", ""), "storageclass", "ssd", "", "")`,
+		Unit:   "tps",
+		During: infiniteRange(),
+		QueryLabels: db.QueryLabels{
+			ZoneLabel:      "cluster_id",
+			TenantLabel:    "tenant_id",
+			NamespaceLabel: "namespace",
+			ClassLabel:     "storageclass",
+		},
+	})
+	require.NoError(t, err)
+
+	ts := time.Date(2020, time.January, 23, 17, 0, 0, 0, time.UTC)
+	samples := make([]report.ResolvedSample, 0)
+	require.NoError(t, report.Run(context.Background(), tx, prom, query.Name, ts,
+		report.WithSampleReporter(func(s report.ResolvedSample) { samples = append(samples, s) }),
+	))
+	require.Len(t, samples, 1)
+	require.Equal(t, "my-product:my-cluster:my-tenant:my-namespace:ssd", samples[0].SourceKey)
+	require.Equal(t, "my-cluster:my-namespace", samples[0].Category, "category should be built from zone and namespace")
+	require.Equal(t, "my-product:my-cluster", samples[0].Product)
+	requireCount(t, tx, 1, "SELECT COUNT(*) FROM tenants WHERE source = 'my-tenant'")
+}
+
+func (s *ReportSuite) TestReport_QueryTemplates() {
+	t := s.T()
+	prom := s.PrometheusAPIClient()
+
+	tx, err := s.DB().Beginx()
+	require.NoError(t, err)
+	defer tx.Rollback()
+
+	ts := time.Date(2020, time.January, 23, 17, 0, 0, 0, time.UTC)
+	query, err := db.CreateQuery(tx, db.Query{
+		Name:   "templated",
+		Query:  strings.ReplaceAll(promTestquery, "%d", "{{ .test_value }}"),
+		Unit:   "tps",
+		During: infiniteRange(),
+	})
+	require.NoError(t, err)
+	_, err = db.CreateQueryParameter(tx, db.QueryParameter{Name: "test_value", Value: "3",
+		During: db.Timerange(db.MustTimestamp(pgtype.NegativeInfinity), db.MustTimestamp(ts.Add(time.Hour)))})
+	require.NoError(t, err)
+	_, err = db.CreateQueryParameter(tx, db.QueryParameter{Name: "test_value", Value: "4",
+		During: db.Timerange(db.MustTimestamp(ts.Add(time.Hour)), db.MustTimestamp(ts.Add(2*time.Hour)))})
+	require.NoError(t, err)
+
+	require.NoError(t, report.Run(context.Background(), tx, prom, query.Name, ts))
+	requireCount(t, tx, 1, "SELECT COUNT(*) FROM facts WHERE query_id = $1 AND quantity = 3", query.Id)
+	require.NoError(t, report.Run(context.Background(), tx, prom, query.Name, ts.Add(time.Hour)))
+	requireCount(t, tx, 1, "SELECT COUNT(*) FROM facts WHERE query_id = $1 AND quantity = 4", query.Id)
+
+	err = report.Run(context.Background(), tx, prom, query.Name, ts.Add(2*time.Hour))
+	require.ErrorContains(t, err, "test_value", "missing parameters should fail the report")
+}
+
 func (s *ReportSuite) TestReport_RunReportCreatesSubFact() {
 	t := s.T()
 	prom := s.PrometheusAPIClient()
@@ -265,6 +579,245 @@ func (s *ReportSuite) TestReport_ProductSpecificityOfSource() {
 	require.Equal(t, specificProduct.Id, fact.ProductId)
 }
 
+func (s *ReportSuite) TestReport_UnresolvedSamplePolicies() {
+	t := s.T()
+	prom := s.PrometheusAPIClient()
+	ctx := context.Background()
+
+	tx, err := s.DB().Beginx()
+	require.NoError(t, err)
+	defer tx.Rollback()
+
+	query, err := db.CreateQuery(tx, db.Query{
+		Name:   "unresolved",
+		Query:  fmt.Sprintf(strings.ReplaceAll(promBarTestquery, "bar-product", "baz-product"), defaultQueryReturnValue),
+		Unit:   "tps",
+		During: infiniteRange(),
+	})
+	require.NoError(t, err)
+
+	ts := time.Now().Truncate(time.Hour)
+	require.ErrorIs(t, report.Run(ctx, tx, prom, query.Name, ts), report.ErrUnresolvedSample)
+
+	require.NoError(t, report.Run(ctx, tx, prom, query.Name, ts, report.WithUnresolvedSamplePolicy(report.SkipUnresolvedSample)))
+	requireCount(t, tx, 0, "SELECT COUNT(*) FROM facts WHERE query_id = $1", query.Id)
+	requireCount(t, tx, 0, "SELECT COUNT(*) FROM quarantined_samples")
+
+	for i := 0; i < 2; i++ {
+		require.NoError(t, report.Run(ctx, tx, prom, query.Name, ts, report.WithUnresolvedSamplePolicy(report.QuarantineUnresolvedSample)))
+	}
+	requireCount(t, tx, 0, "SELECT COUNT(*) FROM facts WHERE query_id = $1", query.Id)
+	requireCount(t, tx, 1, "SELECT COUNT(*) FROM quarantined_samples WHERE query_id = $1", query.Id)
+
+	replayed, unresolved, err := report.ReplayQuarantine(ctx, tx)
+	require.NoError(t, err)
+	require.Equal(t, 0, replayed)
+	require.Equal(t, 1, unresolved)
+
+	s.createProduct(tx, "baz-product")
+	_, err = db.CreateDiscount(tx, db.Discount{Source: "baz-product", During: infiniteRange()})
+	require.NoError(t, err)
+
+	replayed, unresolved, err = report.ReplayQuarantine(ctx, tx)
+	require.NoError(t, err)
+	require.Equal(t, 1, replayed)
+	require.Equal(t, 0, unresolved)
+	fact := s.requireFactForQueryIdAndProductSource(tx, query, "baz-product", ts)
+	require.Equal(t, float64(defaultQueryReturnValue), fact.Quantity)
+	requireCount(t, tx, 0, "SELECT COUNT(*) FROM quarantined_samples")
+}
+
+type warningQuerier struct {
+	report.PromQuerier
+	warnings apiv1.Warnings
+}
+
+func (q warningQuerier) Query(ctx context.Context, query string, ts time.Time) (model.Value, apiv1.Warnings, error) {
+	v, w, err := q.PromQuerier.Query(ctx, query, ts)
+	return v, append(w, q.warnings...), err
+}
+
+func (s *ReportSuite) TestReport_WarningsPolicies() {
+	t := s.T()
+	prom := warningQuerier{s.PrometheusAPIClient(), apiv1.Warnings{"partial response"}}
+	query := s.sampleQuery
+
+	tx, err := s.DB().Beginx()
+	require.NoError(t, err)
+	defer tx.Rollback()
+
+	ts := time.Date(2019, time.March, 1, 0, 0, 0, 0, time.UTC)
+	require.ErrorIs(t, report.Run(context.Background(), tx, prom, query.Name, ts, report.WithWarningsPolicy(report.FailOnWarnings)), report.ErrPrometheusWarnings)
+
+	require.NoError(t, report.Run(context.Background(), tx, prom, query.Name, ts, report.WithWarningsPolicy(report.LogWarnings)))
+	var run db.ReportRun
+	require.NoError(t, sqlx.Get(tx, &run, "SELECT * FROM report_runs WHERE query_name = $1 AND timestamp = $2", query.Name, ts))
+	var warnings []string
+	require.NoError(t, run.Warnings.AssignTo(&warnings))
+	require.Equal(t, []string{"partial response"}, warnings)
+}
+
+type flakyQuerier struct {
+	report.PromQuerier
+	failures int
+	errType  apiv1.ErrorType
+	calls    int
+}
+
+func (q *flakyQuerier) Query(ctx context.Context, query string, ts time.Time) (model.Value, apiv1.Warnings, error) {
+	q.calls++
+	if q.calls <= q.failures {
+		return nil, nil, &apiv1.Error{Type: q.errType, Msg: "flaky"}
+	}
+	return q.PromQuerier.Query(ctx, query, ts)
+}
+
+func (s *ReportSuite) TestReport_RetryPolicy() {
+	t := s.T()
+	query := s.sampleQuery
+
+	tx, err := s.DB().Beginx()
+	require.NoError(t, err)
+	defer tx.Rollback()
+
+	ts := time.Date(2019, time.April, 1, 0, 0, 0, 0, time.UTC)
+	policy := report.WithRetryPolicy(report.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond})
+
+	prom := &flakyQuerier{PromQuerier: s.PrometheusAPIClient(), failures: 2, errType: apiv1.ErrServer}
+	require.NoError(t, report.Run(context.Background(), tx, prom, query.Name, ts, policy))
+	require.Equal(t, 3, prom.calls)
+
+	prom = &flakyQuerier{PromQuerier: s.PrometheusAPIClient(), failures: 3, errType: apiv1.ErrServer}
+	require.Error(t, report.Run(context.Background(), tx, prom, query.Name, ts, policy))
+	require.Equal(t, 3, prom.calls)
+
+	prom = &flakyQuerier{PromQuerier: s.PrometheusAPIClient(), failures: 1, errType: apiv1.ErrBadData}
+	require.Error(t, report.Run(context.Background(), tx, prom, query.Name, ts, policy))
+	require.Equal(t, 1, prom.calls, "bad data errors should not be retried by default")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	prom = &flakyQuerier{PromQuerier: s.PrometheusAPIClient(), failures: 2, errType: apiv1.ErrTimeout}
+	err = report.Run(ctx, tx, prom, query.Name, ts, report.WithRetryPolicy(report.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Hour}))
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Equal(t, 1, prom.calls)
+}
+
+// unreachableQuerier fails the first queries with transport errors of a closed listener.
+type unreachableQuerier struct {
+	report.PromQuerier
+	unreachable report.PromQuerier
+	failures    int
+	calls       int
+}
+
+func (q *unreachableQuerier) Query(ctx context.Context, query string, ts time.Time) (model.Value, apiv1.Warnings, error) {
+	q.calls++
+	if q.calls <= q.failures {
+		return q.unreachable.Query(ctx, query, ts)
+	}
+	return q.PromQuerier.Query(ctx, query, ts)
+}
+
+func (s *ReportSuite) TestReport_RetryPolicyTransportErrors() {
+	t := s.T()
+	query := s.sampleQuery
+
+	tx, err := s.DB().Beginx()
+	require.NoError(t, err)
+	defer tx.Rollback()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+	client, err := api.NewClient(api.Config{Address: "http://" + l.Addr().String()})
+	require.NoError(t, err)
+	unreachable := apiv1.NewAPI(client)
+
+	ts := time.Date(2019, time.April, 1, 0, 0, 0, 0, time.UTC)
+	policy := report.WithRetryPolicy(report.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond})
+
+	prom := &unreachableQuerier{PromQuerier: s.PrometheusAPIClient(), unreachable: unreachable, failures: 2}
+	require.NoError(t, report.Run(context.Background(), tx, prom, query.Name, ts, policy))
+	require.Equal(t, 3, prom.calls)
+
+	prom = &unreachableQuerier{PromQuerier: s.PrometheusAPIClient(), unreachable: unreachable, failures: 3}
+	require.Error(t, report.Run(context.Background(), tx, prom, query.Name, ts, policy))
+	require.Equal(t, 3, prom.calls)
+}
+
+func (s *ReportSuite) TestReport_RetryPolicyPermanentTransportErrors() {
+	t := s.T()
+	query := s.sampleQuery
+
+	tx, err := s.DB().Beginx()
+	require.NoError(t, err)
+	defer tx.Rollback()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+	client, err := api.NewClient(api.Config{Address: "http://" + l.Addr().String()})
+	require.NoError(t, err)
+	unreachable := apiv1.NewAPI(client)
+
+	// The certificate of the test server is not trusted by the client
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer srv.Close()
+	client, err = api.NewClient(api.Config{Address: srv.URL})
+	require.NoError(t, err)
+	untrusted := apiv1.NewAPI(client)
+
+	ts := time.Date(2019, time.April, 1, 0, 0, 0, 0, time.UTC)
+
+	prom := &unreachableQuerier{PromQuerier: s.PrometheusAPIClient(), unreachable: untrusted, failures: 2}
+	err = report.Run(context.Background(), tx, prom, query.Name, ts,
+		report.WithRetryPolicy(report.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}))
+	require.Error(t, err)
+	require.Equal(t, 1, prom.calls, "certificate errors should not be retried")
+
+	prom = &unreachableQuerier{PromQuerier: s.PrometheusAPIClient(), unreachable: unreachable, failures: 2}
+	err = report.Run(context.Background(), tx, prom, query.Name, ts,
+		report.WithRetryPolicy(report.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, RetryableErrors: []apiv1.ErrorType{apiv1.ErrTimeout}}))
+	require.Error(t, err)
+	require.Equal(t, 1, prom.calls, "transport errors should only be retried if configured")
+}
+
+func (s *ReportSuite) TestReport_RangeQueries() {
+	t := s.T()
+	prom := s.PrometheusAPIClient()
+
+	tx, err := s.DB().Beginx()
+	require.NoError(t, err)
+	defer tx.Rollback()
+
+	ts := time.Date(2020, time.January, 23, 17, 0, 0, 0, time.UTC)
+	for aggregation, expected := range map[string]float64{
+		db.RangeAggregationAvg: 7,
+		db.RangeAggregationMax: 7,
+		db.RangeAggregationSum: 6 * 7,
+	} {
+		query, err := db.CreateQuery(tx, db.Query{
+			Name:             "range-" + aggregation,
+			Query:            fmt.Sprintf(promTestquery, 7),
+			Unit:             "tps",
+			During:           infiniteRange(),
+			Mode:             db.QueryModeRange,
+			RangeStepSeconds: 600,
+			RangeAggregation: aggregation,
+		})
+		require.NoError(t, err)
+
+		require.NoError(t, report.Run(context.Background(), tx, prom, query.Name, ts))
+		fact := s.requireFactForQueryIdAndProductSource(tx, query, "my-product:my-cluster", ts)
+		require.Equal(t, expected, fact.Quantity, aggregation)
+	}
+
+	// warningQuerier does not implement QueryRange
+	err = report.Run(context.Background(), tx, warningQuerier{PromQuerier: prom}, "range-sum", ts)
+	require.ErrorContains(t, err, "does not support range queries")
+}
+
 func TestReport(t *testing.T) {
 	suite.Run(t, new(ReportSuite))
 }
@@ -283,6 +836,33 @@ func (s *ReportSuite) createProduct(p db.NamedPreparer, source string) db.Produc
 	return product
 }
 
+// createNewTenantQuery creates a committed query returning a sample for a tenant and category which don't exist yet.
+func (s *ReportSuite) createNewTenantQuery(name, tenant, namespace string) db.Query {
+	query, err := db.CreateQuery(s.DB(), db.Query{
+		Name: name,
+		Query: fmt.Sprintf(`label_replace(label_replace(label_replace(vector(1),
+				"category", "my-cluster:%[2]s", "", ""),
+				"product", "my-product:my-cluster:%[1]s:%[2]s", "", ""),
+				"tenant", "%[1]s", "", "")`, tenant, namespace),
+		Unit:   "tps",
+		During: infiniteRange(),
+	})
+	require.NoError(s.T(), err)
+	return query
+}
+
+// cleanupNewTenant deletes the given queries and the tenant and category created by reporting them.
+func (s *ReportSuite) cleanupNewTenant(tenant, namespace string, queries ...db.Query) {
+	tdb := s.DB()
+	for _, q := range queries {
+		tdb.Exec("DELETE FROM facts WHERE query_id = $1", q.Id)
+		tdb.Exec("DELETE FROM report_runs WHERE query_name = $1", q.Name)
+		tdb.Exec("DELETE FROM queries WHERE id = $1", q.Id)
+	}
+	tdb.Exec("DELETE FROM tenants WHERE source = $1", tenant)
+	tdb.Exec("DELETE FROM categories WHERE source = $1", "my-cluster:"+namespace)
+}
+
 func (s *ReportSuite) requireFactForQueryIdAndProductSource(dbq sqlx.Queryer, q db.Query, productSource string, ts time.Time) db.Fact {
 	var fact db.Fact
 	require.NoError(
@@ -294,6 +874,12 @@ func (s *ReportSuite) requireFactForQueryIdAndProductSource(dbq sqlx.Queryer, q
 	return fact
 }
 
+func requireCount(t *testing.T, q sqlx.Queryer, expected int, query string, args ...interface{}) {
+	var count int
+	require.NoError(t, sqlx.Get(q, &count, query, args...))
+	require.Equal(t, expected, count)
+}
+
 func infiniteRange() pgtype.Tstzrange {
 	return db.Timerange(db.MustTimestamp(pgtype.NegativeInfinity), db.MustTimestamp(pgtype.Infinity))
 }
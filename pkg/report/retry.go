@@ -0,0 +1,121 @@
+package report
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"time"
+
+	apiv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+// promQueryFunc runs a single prometheus query.
+type promQueryFunc func(ctx context.Context) (model.Value, apiv1.Warnings, error)
+
+// queryWithRetry queries prometheus and retries failed queries according to the retry policy.
+// Stops retrying if the context is cancelled.
+func queryWithRetry(ctx context.Context, query promQueryFunc, opts options) (model.Value, apiv1.Warnings, error) {
+	policy := opts.retryPolicy
+	backoff := policy.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		value, warnings, err := queryOnce(ctx, query, opts)
+		if err == nil || attempt >= policy.MaxAttempts || !policy.retryable(ctx, err) {
+			if err != nil && attempt > 1 {
+				err = fmt.Errorf("failed after %d attempts: %w", attempt, err)
+			}
+			return value, warnings, err
+		}
+
+		opts.logger.Info("Retrying prometheus query", "attempt", attempt, "backoff", backoff, "error", err.Error())
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, nil, fmt.Errorf("cancelled after %d attempts: %w (last error: %s)", attempt, ctx.Err(), err)
+		case <-timer.C:
+		}
+
+		backoff *= 2
+		if policy.MaxBackoff > 0 && backoff > policy.MaxBackoff {
+			backoff = policy.MaxBackoff
+		}
+	}
+}
+
+func queryOnce(ctx context.Context, query promQueryFunc, opts options) (model.Value, apiv1.Warnings, error) {
+	if opts.prometheusQueryTimeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.prometheusQueryTimeout)
+		defer cancel()
+	}
+	return query(ctx)
+}
+
+// retryable returns true if the error is of a retryable prometheus error type.
+// Timeouts of the query context are considered apiv1.ErrTimeout errors as long as the parent context is not done.
+// Transport errors, such as refused or reset connections, are considered ErrTransport errors.
+func (p RetryPolicy) retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	var errType apiv1.ErrorType
+	var apiErr *apiv1.Error
+	if errors.As(err, &apiErr) {
+		errType = apiErr.Type
+	} else if errors.Is(err, context.DeadlineExceeded) {
+		errType = apiv1.ErrTimeout
+	} else if isTransportError(err) {
+		errType = ErrTransport
+	} else {
+		return false
+	}
+
+	retryable := p.RetryableErrors
+	if retryable == nil {
+		retryable = DefaultRetryableErrors
+	}
+	for _, t := range retryable {
+		if t == errType {
+			return true
+		}
+	}
+	return false
+}
+
+// isTransportError returns true if the error was returned by the HTTP client instead of prometheus.
+// Errors of the request context and permanent errors, such as invalid certificates or unknown hosts, are not considered transport errors.
+func isTransportError(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) || isPermanentError(err) {
+		return false
+	}
+	var netErr net.Error
+	var urlErr *url.Error
+	return errors.As(err, &netErr) || errors.As(err, &urlErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// isPermanentError returns true if the error is a certificate, TLS or unknown host error which won't go away by retrying.
+func isPermanentError(err error) bool {
+	var (
+		unknownAuthorityErr    x509.UnknownAuthorityError
+		certificateInvalidErr  x509.CertificateInvalidError
+		hostnameErr            x509.HostnameError
+		systemRootsErr         x509.SystemRootsError
+		constraintViolationErr x509.ConstraintViolationError
+		recordHeaderErr        tls.RecordHeaderError
+		dnsErr                 *net.DNSError
+	)
+	return errors.As(err, &unknownAuthorityErr) ||
+		errors.As(err, &certificateInvalidErr) ||
+		errors.As(err, &hostnameErr) ||
+		errors.As(err, &systemRootsErr) ||
+		errors.As(err, &constraintViolationErr) ||
+		errors.As(err, &recordHeaderErr) ||
+		(errors.As(err, &dnsErr) && dnsErr.IsNotFound)
+}
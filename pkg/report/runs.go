@@ -0,0 +1,132 @@
+package report
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/appuio/appuio-cloud-reporting/pkg/db"
+)
+
+const (
+	// RunSucceeded is the status of a successful report run.
+	RunSucceeded = "succeeded"
+	// RunFailed is the status of a failed report run.
+	RunFailed = "failed"
+	// RunMissing is the status of an hour without a report run.
+	RunMissing = "missing"
+)
+
+// HourStatus is the status of the reports of a query for a single hour.
+type HourStatus struct {
+	Timestamp time.Time
+	// Status is one of RunSucceeded, RunFailed or RunMissing.
+	// An hour succeeded if at least one run succeeded.
+	Status string
+	// Error is the error of the most recent failed run.
+	Error string
+}
+
+// QueryStatus summarizes the report runs of a query.
+type QueryStatus struct {
+	Query string
+	// Hours holds the status of every hour the query was valid in ordered by time.
+	Hours []HourStatus
+}
+
+// Count returns the number of hours with the given status.
+func (s QueryStatus) Count(status string) int {
+	n := 0
+	for _, h := range s.Hours {
+		if h.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+var statusQuery = db.ExpectedReportsQuery(`
+	SELECT expected.name AS query, expected.timestamp,
+			CASE WHEN runs.succeeded THEN 'succeeded' WHEN runs.succeeded IS NULL THEN 'missing' ELSE 'failed' END AS status,
+			COALESCE(runs.error, '') AS error
+		FROM expected
+		LEFT JOIN runs ON (runs.query_name = expected.name AND runs.timestamp = expected.timestamp)
+		ORDER BY expected.name, expected.timestamp`,
+	`runs AS (
+			SELECT query_name, timestamp,
+					bool_or(status = 'succeeded') AS succeeded,
+					(array_agg(error ORDER BY started_at DESC) FILTER (WHERE status = 'failed'))[1] AS error
+				FROM report_runs
+				WHERE timestamp >= $1 AND timestamp < $2
+				GROUP BY query_name, timestamp
+		)`,
+)
+
+// Status returns the status of every hour in the given period for all top-level queries valid during that hour.
+// Returns the queries ordered by name.
+func Status(ctx context.Context, q sqlx.QueryerContext, from, to time.Time) ([]QueryStatus, error) {
+	var rows []struct {
+		Query string
+		HourStatus
+	}
+	if err := sqlx.SelectContext(ctx, q, &rows, statusQuery, from, to); err != nil {
+		return nil, fmt.Errorf("failed to load report runs: %w", err)
+	}
+
+	statuses := make([]QueryStatus, 0)
+	for _, r := range rows {
+		if len(statuses) == 0 || statuses[len(statuses)-1].Query != r.Query {
+			statuses = append(statuses, QueryStatus{Query: r.Query})
+		}
+		r.HourStatus.Timestamp = r.HourStatus.Timestamp.In(time.UTC)
+		statuses[len(statuses)-1].Hours = append(statuses[len(statuses)-1].Hours, r.HourStatus)
+	}
+	return statuses, nil
+}
+
+func newReportRun(queryName string, ts, started time.Time, res runResult, runErr error, opts options) db.ReportRun {
+	run := db.ReportRun{
+		QueryName:       queryName,
+		Timestamp:       ts,
+		Status:          RunSucceeded,
+		Samples:         res.samples,
+		StartedAt:       started,
+		DurationSeconds: time.Since(started).Seconds(),
+		Version:         opts.version,
+	}
+	warnings := res.warnings
+	if warnings == nil {
+		warnings = []string{}
+	}
+	// Setting a string slice never fails
+	_ = run.Warnings.Set(warnings)
+	if runErr != nil {
+		run.Status = RunFailed
+		run.Error = runErr.Error()
+	}
+	return run
+}
+
+func recordRun(ctx context.Context, e sqlx.ExtContext, run db.ReportRun) error {
+	_, err := sqlx.NamedExecContext(ctx, e,
+		`INSERT INTO report_runs
+				(query_name,timestamp,status,samples,started_at,duration_seconds,warnings,error,version)
+			VALUES
+				(:query_name,:timestamp,:status,:samples,:started_at,:duration_seconds,:warnings,:error,:version)`,
+		run)
+	if err != nil {
+		return fmt.Errorf("failed to record report run: %w", err)
+	}
+	return nil
+}
+
+// recordFailedRun records the failed run outside of the rolled back transaction of the report.
+// Returns the error of the run, annotated if the run could not be recorded.
+func recordFailedRun(ctx context.Context, database *sqlx.DB, run db.ReportRun, runErr error) error {
+	if err := recordRun(ctx, database, run); err != nil {
+		return fmt.Errorf("%w (failed to record run: %s)", runErr, err)
+	}
+	return runErr
+}
@@ -0,0 +1,179 @@
+// Package schedule runs the reports of every hour continuously.
+package schedule
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/appuio/appuio-cloud-reporting/pkg/report"
+)
+
+// DefaultGiveUpAfter is the default time failed hours are retried for.
+const DefaultGiveUpAfter = 24 * time.Hour
+
+// Scheduler runs the reports for all queries every hour.
+// The last hour for which all reports succeeded is stored in the database.
+// Missed and failed hours are caught up on the next run.
+type Scheduler struct {
+	DB   *sqlx.DB
+	Prom report.PromQuerier
+	Log  logr.Logger
+
+	// Delay is the time to wait after the end of an hour before running its reports.
+	// Allows prometheus to receive all samples of the hour.
+	Delay time.Duration
+	// Begin is the first hour to run if no successful hour is stored in the database.
+	// Defaults to the last complete hour.
+	Begin time.Time
+	// GiveUpAfter is the time failed hours are retried for after they became complete.
+	// Older failed hours are skipped, their failures stay recorded in the report runs.
+	// This keeps a permanently failing hour from re-running all later hours on every run.
+	// Defaults to DefaultGiveUpAfter.
+	GiveUpAfter time.Duration
+	// QueryNames limits the queries to run. All top-level queries valid at an hour are run if empty.
+	QueryNames []string
+	// Options are passed to report.RunQueries.
+	Options []report.Option
+
+	// Now returns the current time. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// Run runs the pending reports and then waits for the next hour until the context is cancelled.
+// Failed reports are logged and retried on the next run.
+// Returns nil if the context is cancelled.
+func (s *Scheduler) Run(ctx context.Context) error {
+	for {
+		if err := s.RunPending(ctx); err != nil && ctx.Err() == nil {
+			s.Log.Error(err, "Failed to run pending reports")
+		}
+
+		next := s.nextRun()
+		s.Log.V(1).Info("Waiting for next run", "next", next.Format(time.RFC3339))
+		timer := time.NewTimer(next.Sub(s.now()))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			s.Log.Info("Shutting down scheduler")
+			return nil
+		case <-timer.C:
+		}
+	}
+}
+
+// RunPending runs the reports for all hours after the last successful hour up to the last complete hour.
+// The last successful hour is advanced to the hour before the first failed hour which is still retried, see GiveUpAfter.
+func (s *Scheduler) RunPending(ctx context.Context) error {
+	until := s.lastCompleteHour()
+
+	from, err := s.firstPendingHour(ctx)
+	if err != nil {
+		return err
+	}
+	if from.After(until) {
+		s.Log.V(1).Info("No pending hours", "from", from.Format(time.RFC3339))
+		return nil
+	}
+
+	s.Log.Info("Running reports", "from", from.Format(time.RFC3339), "until", until.Format(time.RFC3339))
+	summaries, err := report.RunQueries(ctx, s.DB, s.Prom, s.QueryNames, from, until.Add(time.Hour), s.Options...)
+	if err != nil {
+		return err
+	}
+
+	lastSuccessful := until
+	retryFrom := until.Add(-s.giveUpAfter())
+	for _, summary := range summaries {
+		for _, f := range summary.Failed {
+			if f.Timestamp.Before(retryFrom) {
+				s.Log.Error(f.Err, "Report failed, giving up", "query", summary.Query, "timestamp", f.Timestamp.Format(time.RFC3339))
+				continue
+			}
+			s.Log.Error(f.Err, "Report failed", "query", summary.Query, "timestamp", f.Timestamp.Format(time.RFC3339))
+			if !f.Timestamp.After(lastSuccessful) {
+				lastSuccessful = f.Timestamp.Add(-time.Hour)
+			}
+		}
+	}
+	if lastSuccessful.Before(from) {
+		return fmt.Errorf("reports for %s failed", from.Format(time.RFC3339))
+	}
+
+	if err := SetLastSuccessfulHour(ctx, s.DB, lastSuccessful); err != nil {
+		return err
+	}
+	s.Log.Info("Ran reports", "lastSuccessfulHour", lastSuccessful.Format(time.RFC3339))
+	if lastSuccessful.Before(until) {
+		return fmt.Errorf("reports after %s failed", lastSuccessful.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// LastSuccessfulHour returns the last hour for which all reports succeeded.
+// Returns false if no hour is stored.
+func LastSuccessfulHour(ctx context.Context, q sqlx.QueryerContext) (time.Time, bool, error) {
+	var last time.Time
+	err := sqlx.GetContext(ctx, q, &last, "SELECT last_successful_hour FROM scheduler_state")
+	if errors.Is(err, sql.ErrNoRows) {
+		return last, false, nil
+	} else if err != nil {
+		return last, false, fmt.Errorf("failed to load last successful hour: %w", err)
+	}
+	return last.In(time.UTC), true, nil
+}
+
+// SetLastSuccessfulHour stores the last hour for which all reports succeeded.
+func SetLastSuccessfulHour(ctx context.Context, e sqlx.ExecerContext, hour time.Time) error {
+	_, err := e.ExecContext(ctx,
+		`INSERT INTO scheduler_state (last_successful_hour) VALUES ($1)
+			ON CONFLICT (id) DO UPDATE SET last_successful_hour = $1`,
+		hour)
+	if err != nil {
+		return fmt.Errorf("failed to store last successful hour: %w", err)
+	}
+	return nil
+}
+
+func (s *Scheduler) firstPendingHour(ctx context.Context) (time.Time, error) {
+	last, ok, err := LastSuccessfulHour(ctx, s.DB)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if ok {
+		return last.Add(time.Hour), nil
+	}
+	if !s.Begin.IsZero() {
+		return s.Begin.In(time.UTC).Truncate(time.Hour), nil
+	}
+	return s.lastCompleteHour(), nil
+}
+
+func (s *Scheduler) giveUpAfter() time.Duration {
+	if s.GiveUpAfter > 0 {
+		return s.GiveUpAfter
+	}
+	return DefaultGiveUpAfter
+}
+
+// lastCompleteHour returns the start of the last hour which ended at least Delay ago.
+func (s *Scheduler) lastCompleteHour() time.Time {
+	return s.now().In(time.UTC).Add(-s.Delay).Truncate(time.Hour).Add(-time.Hour)
+}
+
+// nextRun returns the time the next hour becomes complete.
+func (s *Scheduler) nextRun() time.Time {
+	return s.lastCompleteHour().Add(2*time.Hour + s.Delay)
+}
+
+func (s *Scheduler) now() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+	return time.Now()
+}
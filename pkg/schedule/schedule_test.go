@@ -0,0 +1,155 @@
+package schedule_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/jackc/pgtype"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/appuio/appuio-cloud-reporting/pkg/db"
+	"github.com/appuio/appuio-cloud-reporting/pkg/schedule"
+	"github.com/appuio/appuio-cloud-reporting/pkg/testsuite"
+)
+
+type ScheduleSuite struct {
+	testsuite.Suite
+}
+
+const promTestquery = `
+	label_replace(
+		label_replace(
+			vector(42),
+			"category", "my-cluster:my-namespace", "", ""
+		),
+		"product", "my-product:my-cluster:my-tenant:my-namespace", "", ""
+	)
+`
+
+func (s *ScheduleSuite) SetupSuite() {
+	s.Suite.SetupSuite()
+
+	t := s.T()
+	tdb := s.DB()
+
+	_, err := db.CreateProduct(tdb, db.Product{Source: "my-product", Amount: 1, Unit: "tps", During: infiniteRange()})
+	require.NoError(t, err)
+	_, err = db.CreateDiscount(tdb, db.Discount{Source: "my-product", During: infiniteRange()})
+	require.NoError(t, err)
+	_, err = db.CreateQuery(tdb, db.Query{Name: "schedule-test", Query: promTestquery, Unit: "tps", During: infiniteRange()})
+	require.NoError(t, err)
+}
+
+func (s *ScheduleSuite) TestRunPending_CatchesUp() {
+	t := s.T()
+	ctx := context.Background()
+	tdb := s.DB()
+
+	base := time.Date(2020, time.January, 23, 17, 0, 0, 0, time.UTC)
+	now := base.Add(3*time.Hour + 5*time.Minute)
+
+	scheduler := &schedule.Scheduler{
+		DB:         tdb,
+		Prom:       s.PrometheusAPIClient(),
+		Log:        logr.Discard(),
+		Delay:      5 * time.Minute,
+		Begin:      base,
+		QueryNames: []string{"schedule-test"},
+		Now:        func() time.Time { return now },
+	}
+
+	require.NoError(t, scheduler.RunPending(ctx))
+	requireLastSuccessfulHour(t, tdb, base.Add(2*time.Hour))
+	requireFactCount(t, tdb, 3)
+
+	require.NoError(t, scheduler.RunPending(ctx))
+	requireFactCount(t, tdb, 3)
+
+	now = now.Add(time.Hour - time.Minute)
+	require.NoError(t, scheduler.RunPending(ctx))
+	requireLastSuccessfulHour(t, tdb, base.Add(2*time.Hour))
+
+	now = now.Add(time.Minute)
+	require.NoError(t, scheduler.RunPending(ctx))
+	requireLastSuccessfulHour(t, tdb, base.Add(3*time.Hour))
+	requireFactCount(t, tdb, 4)
+}
+
+func (s *ScheduleSuite) TestRunPending_GivesUpOnFailedHours() {
+	t := s.T()
+	ctx := context.Background()
+	tdb := s.DB()
+
+	_, err := tdb.Exec("DELETE FROM scheduler_state")
+	require.NoError(t, err)
+	defer tdb.Exec("DELETE FROM scheduler_state")
+	defer tdb.Exec("DELETE FROM report_runs WHERE query_name = 'schedule-missing'")
+
+	base := time.Date(2020, time.March, 2, 0, 0, 0, 0, time.UTC)
+	now := base.Add(5*time.Hour + 5*time.Minute)
+
+	scheduler := &schedule.Scheduler{
+		DB:          tdb,
+		Prom:        s.PrometheusAPIClient(),
+		Log:         logr.Discard(),
+		Delay:       5 * time.Minute,
+		Begin:       base,
+		GiveUpAfter: 2 * time.Hour,
+		QueryNames:  []string{"schedule-missing"},
+		Now:         func() time.Time { return now },
+	}
+
+	require.Error(t, scheduler.RunPending(ctx))
+	requireLastSuccessfulHour(t, tdb, base.Add(time.Hour))
+
+	require.Error(t, scheduler.RunPending(ctx), "hours within the retry window should be retried")
+	requireLastSuccessfulHour(t, tdb, base.Add(time.Hour))
+
+	now = now.Add(2 * time.Hour)
+	require.Error(t, scheduler.RunPending(ctx))
+	requireLastSuccessfulHour(t, tdb, base.Add(3*time.Hour))
+
+	var failed int
+	require.NoError(t, sqlx.Get(tdb, &failed, "SELECT COUNT(DISTINCT timestamp) FROM report_runs WHERE query_name = 'schedule-missing' AND status = 'failed'"))
+	require.Equal(t, 7, failed, "skipped hours should stay recorded as failed")
+}
+
+func (s *ScheduleSuite) TestRun_StopsOnCancel() {
+	t := s.T()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	scheduler := &schedule.Scheduler{
+		DB:         s.DB(),
+		Prom:       s.PrometheusAPIClient(),
+		Log:        logr.Discard(),
+		QueryNames: []string{"schedule-test"},
+	}
+	require.NoError(t, scheduler.Run(ctx))
+}
+
+func TestSchedule(t *testing.T) {
+	suite.Run(t, new(ScheduleSuite))
+}
+
+func requireLastSuccessfulHour(t *testing.T, q sqlx.QueryerContext, expected time.Time) {
+	last, ok, err := schedule.LastSuccessfulHour(context.Background(), q)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, expected, last)
+}
+
+func requireFactCount(t *testing.T, q sqlx.Queryer, expected int) {
+	var count int
+	require.NoError(t, sqlx.Get(q, &count, "SELECT COUNT(*) FROM facts"))
+	require.Equal(t, expected, count)
+}
+
+func infiniteRange() pgtype.Tstzrange {
+	return db.Timerange(db.MustTimestamp(pgtype.NegativeInfinity), db.MustTimestamp(pgtype.Infinity))
+}
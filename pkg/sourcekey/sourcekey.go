@@ -5,7 +5,10 @@ import (
 	"strings"
 )
 
-const elementSeparator = ":"
+const (
+	elementSeparator = ":"
+	wildcard         = "*"
+)
 
 // SourceKey represents a source key to look up dimensions objects (currently queries and products).
 // It implements the lookup logic found in https://kb.vshn.ch/appuio-cloud/references/architecture/metering-data-flow.html#_system_idea.
@@ -45,6 +48,47 @@ func (k SourceKey) LookupKeys() []string {
 	return generateSourceKeys(k.Query, k.Zone, k.Tenant, k.Namespace, k.Class)
 }
 
+// ValidateLookupKey checks whether the given key, as stored in the source of a dimension object, can be matched by the lookup keys of any source key.
+// Keys consisting of four or five elements must be valid source keys.
+// Wildcards are only accepted in positions generated by LookupKeys.
+func ValidateLookupKey(raw string) error {
+	parts := strings.Split(raw, elementSeparator)
+	if len(parts) > 5 {
+		return fmt.Errorf("expected key with 1 to 5 elements separated by `%s` got %d", elementSeparator, len(parts))
+	}
+	for i, p := range parts {
+		if p == "" {
+			return fmt.Errorf("element %d of key %q is empty", i+1, raw)
+		}
+	}
+	if parts[0] == wildcard {
+		return fmt.Errorf("query element of key %q can't be a wildcard", raw)
+	}
+
+	// Build a concrete key the given key should match and check if the given key is one of its lookup keys.
+	const placeholder = "x"
+	concrete := []string{placeholder, placeholder, placeholder, placeholder}
+	if len(parts) == 5 {
+		concrete = append(concrete, placeholder)
+	}
+	for i, p := range parts {
+		if p != wildcard {
+			concrete[i] = p
+		}
+	}
+
+	key, err := Parse(strings.Join(concrete, elementSeparator))
+	if err != nil {
+		return err
+	}
+	for _, k := range key.LookupKeys() {
+		if k == raw {
+			return nil
+		}
+	}
+	return fmt.Errorf("key %q can't be matched by any source key, wildcards are only supported between the query and the last element", raw)
+}
+
 func generateSourceKeys(query, zone, tenant, namespace, class string) []string {
 	keys := make([]string, 0)
 	base := []string{query, zone, tenant, namespace}
@@ -63,7 +107,7 @@ func generateSourceKeys(query, zone, tenant, namespace, class string) []string {
 			for _, wcpos := range reverse(perms) {
 				elements := append([]string{}, base[:i]...)
 				for _, p := range wcpos {
-					elements[p] = "*"
+					elements[p] = wildcard
 				}
 				keys = append(keys, strings.Join(elements, elementSeparator))
 			}
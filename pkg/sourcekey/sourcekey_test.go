@@ -105,3 +105,30 @@ func TestGenerateSourceKeysWithClass(t *testing.T) {
 		"appuio_cloud_storage",
 	})
 }
+
+func TestValidateLookupKey(t *testing.T) {
+	for _, valid := range []string{
+		"appuio_cloud_storage",
+		"appuio_cloud_storage:c-appuio-cloudscale-lpg-2",
+		"appuio_cloud_storage:*:acme-corp",
+		"appuio_cloud_storage:*:*:sparkling-sound-1234",
+		"appuio_cloud_storage:c-appuio-cloudscale-lpg-2:acme-corp:sparkling-sound-1234",
+		"appuio_cloud_storage:*:*:*:ssd",
+		"appuio_cloud_storage:c-appuio-cloudscale-lpg-2:acme-corp:sparkling-sound-1234:ssd",
+	} {
+		require.NoError(t, sourcekey.ValidateLookupKey(valid), valid)
+	}
+
+	for _, invalid := range []string{
+		"",
+		"*",
+		"appuio_cloud_storage:*",
+		"appuio_cloud_storage:c-appuio-cloudscale-lpg-2:*",
+		"appuio_cloud_storage:*:acme-corp:*",
+		"appuio_cloud_storage:c-appuio-cloudscale-lpg-2:acme-corp:sparkling-sound-1234:*",
+		"appuio_cloud_storage:c-appuio-cloudscale-lpg-2:",
+		"appuio_cloud_storage:c-appuio-cloudscale-lpg-2:acme-corp:sparkling-sound-1234:ssd:extra",
+	} {
+		require.Error(t, sourcekey.ValidateLookupKey(invalid), invalid)
+	}
+}
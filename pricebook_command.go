@@ -0,0 +1,147 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/urfave/cli/v2"
+
+	"github.com/appuio/appuio-cloud-reporting/pkg/db"
+	"github.com/appuio/appuio-cloud-reporting/pkg/pricebook"
+)
+
+type pricebookCommand struct {
+	DatabaseURL string
+	File        string
+	Prune       bool
+	DryRun      bool
+	Output      string
+}
+
+var pricebookCommandName = "pricebook"
+
+func newPricebookCommand() *cli.Command {
+	command := &pricebookCommand{}
+	return &cli.Command{
+		Name:  pricebookCommandName,
+		Usage: "Manage queries, query parameters, products and discounts declaratively",
+		Subcommands: []*cli.Command{
+			{
+				Name:   "apply",
+				Usage:  "Apply the queries, query parameters, products and discounts of a price book in one transaction",
+				Before: command.before,
+				Action: command.apply,
+				Flags: []cli.Flag{
+					newDbURLFlag(&command.DatabaseURL),
+					&cli.StringFlag{Name: "file", Aliases: []string{"f"}, Usage: "Price book in the YAML or JSON format, - reads from stdin",
+						EnvVars: envVars("PRICEBOOK_FILE"), Destination: &command.File, Required: true, DefaultText: defaultTestForRequiredFlags},
+					&cli.BoolFlag{Name: "prune", Usage: "Delete queries, query parameters, products and discounts not in the price book",
+						EnvVars: envVars("PRICEBOOK_PRUNE"), Destination: &command.Prune},
+					&cli.BoolFlag{Name: "dry-run", Usage: "Print the plan without applying it",
+						EnvVars: envVars("DRY_RUN"), Destination: &command.DryRun},
+				},
+			},
+			{
+				Name:   "export",
+				Usage:  "Export the queries, query parameters, products and discounts as a price book",
+				Before: command.before,
+				Action: command.export,
+				Flags: []cli.Flag{
+					newDbURLFlag(&command.DatabaseURL),
+					&cli.StringFlag{Name: "output", Aliases: []string{"o"}, Usage: "Output format (values: [yaml, json])",
+						EnvVars: envVars("OUTPUT"), Destination: &command.Output, Value: "yaml"},
+				},
+			},
+		},
+	}
+}
+
+func (cmd *pricebookCommand) before(context *cli.Context) error {
+	return LogMetadata(context)
+}
+
+func (cmd *pricebookCommand) apply(cliCtx *cli.Context) error {
+	ctx := cliCtx.Context
+	log := AppLogger(ctx).WithName(pricebookCommandName)
+
+	pb, err := cmd.load()
+	if err != nil {
+		return err
+	}
+
+	rdb, err := openDB(cliCtx, pricebookCommandName, cmd.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer rdb.Close()
+
+	return db.RunInTransaction(ctx, rdb, func(tx *sqlx.Tx) error {
+		changes, err := pricebook.Plan(ctx, tx, pb, cmd.Prune)
+		if err != nil {
+			return err
+		}
+		for _, c := range changes {
+			fmt.Println(c)
+		}
+		if len(changes) == 0 {
+			log.Info("No changes")
+			return nil
+		}
+		if cmd.DryRun {
+			log.Info("Dry run, not applying changes", "changes", len(changes))
+			return nil
+		}
+
+		log.Info("Applying changes", "changes", len(changes))
+		return pricebook.Apply(ctx, tx, changes)
+	})
+}
+
+func (cmd *pricebookCommand) load() (pricebook.PriceBook, error) {
+	var r io.Reader = os.Stdin
+	if cmd.File != "-" {
+		f, err := os.Open(cmd.File)
+		if err != nil {
+			return pricebook.PriceBook{}, fmt.Errorf("could not open price book: %w", err)
+		}
+		defer f.Close()
+		r = f
+	}
+	return pricebook.Load(r)
+}
+
+func (cmd *pricebookCommand) export(cliCtx *cli.Context) error {
+	ctx := cliCtx.Context
+	log := AppLogger(ctx).WithName(pricebookCommandName)
+
+	encode, ok := map[string]func(pricebook.PriceBook, io.Writer) error{
+		"yaml": pricebook.PriceBook.EncodeYAML,
+		"json": pricebook.PriceBook.EncodeJSON,
+	}[cmd.Output]
+	if !ok {
+		return fmt.Errorf("unknown output format %q", cmd.Output)
+	}
+
+	rdb, err := openDB(cliCtx, pricebookCommandName, cmd.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer rdb.Close()
+
+	log.V(1).Info("Begin transaction")
+	tx, err := rdb.BeginTxx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	pb, err := pricebook.Export(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	return encode(pb, os.Stdout)
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/urfave/cli/v2"
+
+	"github.com/appuio/appuio-cloud-reporting/pkg/db"
+	"github.com/appuio/appuio-cloud-reporting/pkg/sourcekey"
+)
+
+type productsCommand struct {
+	DatabaseURL string
+	ID          string
+	Source      string
+	Target      string
+	Amount      float64
+	Unit        string
+	Currency    string
+	During      string
+	At          *time.Time
+}
+
+var productsCommandName = "products"
+
+func newProductsCommand() *cli.Command {
+	command := &productsCommand{}
+	return &cli.Command{
+		Name:  productsCommandName,
+		Usage: "Manage priced products and their validity",
+		Subcommands: []*cli.Command{
+			{
+				Name:   "list",
+				Usage:  "List products",
+				Before: command.before,
+				Action: command.list,
+				Flags: []cli.Flag{
+					newDbURLFlag(&command.DatabaseURL),
+					newAtFlag(false, "Only list products valid at this timestamp"),
+				},
+			},
+			{
+				Name:   "create",
+				Usage:  "Create a new product",
+				Before: command.before,
+				Action: command.create,
+				Flags: []cli.Flag{
+					newDbURLFlag(&command.DatabaseURL),
+					command.newSourceFlag(true),
+					command.newTargetFlag(),
+					command.newAmountFlag(true),
+					command.newUnitFlag(),
+					command.newCurrencyFlag(),
+					newDuringFlag(&command.During, "Validity of the product"),
+				},
+			},
+			{
+				Name:   "update",
+				Usage:  "Update the given fields of an existing product",
+				Before: command.before,
+				Action: command.update,
+				Flags: []cli.Flag{
+					newDbURLFlag(&command.DatabaseURL),
+					command.newIDFlag(),
+					command.newSourceFlag(false),
+					command.newTargetFlag(),
+					command.newAmountFlag(false),
+					command.newUnitFlag(),
+					command.newCurrencyFlag(),
+					newDuringFlag(&command.During, "Validity of the product"),
+				},
+			},
+			{
+				Name:   "close",
+				Usage:  "End the validity of the current product at the given timestamp and create a new price starting at that timestamp",
+				Before: command.before,
+				Action: command.close,
+				Flags: []cli.Flag{
+					newDbURLFlag(&command.DatabaseURL),
+					command.newSourceFlag(true),
+					newAtFlag(true, "Timestamp at which the current product ends and the new one starts"),
+					command.newAmountFlag(true),
+					&cli.StringFlag{Name: "target", Usage: "Target of the new product (default: target of the closed product)", Destination: &command.Target},
+					&cli.StringFlag{Name: "unit", Usage: "Unit of the new product (default: unit of the closed product)", Destination: &command.Unit},
+					&cli.StringFlag{Name: "currency", Usage: "Currency of the new product (default: currency of the closed product)", Destination: &command.Currency},
+				},
+			},
+			{
+				Name:   "delete",
+				Usage:  "Delete a product not referenced by any facts",
+				Before: command.before,
+				Action: command.delete,
+				Flags: []cli.Flag{
+					newDbURLFlag(&command.DatabaseURL),
+					command.newIDFlag(),
+				},
+			},
+		},
+	}
+}
+
+func (cmd *productsCommand) newIDFlag() *cli.StringFlag {
+	return &cli.StringFlag{Name: "id", Usage: "ID of the product",
+		Destination: &cmd.ID, Required: true, DefaultText: defaultTestForRequiredFlags}
+}
+
+func (cmd *productsCommand) newSourceFlag(required bool) *cli.StringFlag {
+	return &cli.StringFlag{Name: "source", Usage: "Source of the product in the form of query:zone:tenant:namespace:class, can contain wildcards",
+		Destination: &cmd.Source, Required: required}
+}
+
+func (cmd *productsCommand) newTargetFlag() *cli.StringFlag {
+	return &cli.StringFlag{Name: "target", Usage: "Target of the product in the ERP",
+		Destination: &cmd.Target}
+}
+
+func (cmd *productsCommand) newAmountFlag(required bool) *cli.Float64Flag {
+	return &cli.Float64Flag{Name: "amount", Usage: "Price per unit of the product",
+		Destination: &cmd.Amount, Required: required}
+}
+
+func (cmd *productsCommand) newUnitFlag() *cli.StringFlag {
+	return &cli.StringFlag{Name: "unit", Usage: "Unit of the product (example: MiB)",
+		Destination: &cmd.Unit}
+}
+
+func (cmd *productsCommand) newCurrencyFlag() *cli.StringFlag {
+	return &cli.StringFlag{Name: "currency", Usage: fmt.Sprintf("ISO 4217 code of the currency of the amount (default: %s)", db.DefaultCurrency),
+		Destination: &cmd.Currency}
+}
+
+func (cmd *productsCommand) before(context *cli.Context) error {
+	cmd.At = context.Timestamp("at")
+	return LogMetadata(context)
+}
+
+// validateSource checks the given source before writing it to the database.
+// Products with a source not matching any source key are never used.
+func (cmd *productsCommand) validateSource() error {
+	if err := sourcekey.ValidateLookupKey(cmd.Source); err != nil {
+		return fmt.Errorf("invalid source: %w", err)
+	}
+	return nil
+}
+
+func (cmd *productsCommand) list(cliCtx *cli.Context) error {
+	rdb, err := openDB(cliCtx, productsCommandName, cmd.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer rdb.Close()
+
+	products, err := db.ListProducts(cliCtx.Context, rdb, cmd.At)
+	if err != nil {
+		return err
+	}
+	printProducts(os.Stdout, products...)
+	return nil
+}
+
+func (cmd *productsCommand) create(cliCtx *cli.Context) error {
+	if err := cmd.validateSource(); err != nil {
+		return err
+	}
+	during, err := db.ParseTimerange(cmd.During)
+	if err != nil {
+		return fmt.Errorf("invalid during: %w", err)
+	}
+
+	rdb, err := openDB(cliCtx, productsCommandName, cmd.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer rdb.Close()
+
+	var created db.Product
+	err = db.RunInTransaction(cliCtx.Context, rdb, func(tx *sqlx.Tx) error {
+		created, err = db.CreateProduct(tx, db.Product{
+			Source:   cmd.Source,
+			Target:   db.NullString(cmd.Target),
+			Amount:   cmd.Amount,
+			Unit:     cmd.Unit,
+			Currency: cmd.Currency,
+			During:   during,
+		})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("could not create product: %w", err)
+	}
+	printProducts(os.Stdout, created)
+	return nil
+}
+
+func (cmd *productsCommand) update(cliCtx *cli.Context) error {
+	if cliCtx.IsSet("source") {
+		if err := cmd.validateSource(); err != nil {
+			return err
+		}
+	}
+	rdb, err := openDB(cliCtx, productsCommandName, cmd.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer rdb.Close()
+
+	var updated db.Product
+	err = db.RunInTransaction(cliCtx.Context, rdb, func(tx *sqlx.Tx) error {
+		product, err := db.GetProduct(cliCtx.Context, tx, cmd.ID)
+		if err != nil {
+			return err
+		}
+		if cliCtx.IsSet("source") {
+			product.Source = cmd.Source
+		}
+		if cliCtx.IsSet("target") {
+			product.Target = db.NullString(cmd.Target)
+		}
+		if cliCtx.IsSet("amount") {
+			product.Amount = cmd.Amount
+		}
+		if cliCtx.IsSet("unit") {
+			product.Unit = cmd.Unit
+		}
+		if cliCtx.IsSet("currency") {
+			product.Currency = cmd.Currency
+		}
+		if cliCtx.IsSet("during") {
+			if product.During, err = db.ParseTimerange(cmd.During); err != nil {
+				return fmt.Errorf("invalid during: %w", err)
+			}
+		}
+		updated, err = db.UpdateProduct(cliCtx.Context, tx, product)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("could not update product: %w", err)
+	}
+	printProducts(os.Stdout, updated)
+	return nil
+}
+
+func (cmd *productsCommand) close(cliCtx *cli.Context) error {
+	if err := cmd.validateSource(); err != nil {
+		return err
+	}
+	rdb, err := openDB(cliCtx, productsCommandName, cmd.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer rdb.Close()
+
+	var closed, created db.Product
+	err = db.RunInTransaction(cliCtx.Context, rdb, func(tx *sqlx.Tx) error {
+		closed, created, err = db.CloseProduct(cliCtx.Context, tx, cmd.Source, *cmd.At, db.Product{
+			Target:   db.NullString(cmd.Target),
+			Amount:   cmd.Amount,
+			Unit:     cmd.Unit,
+			Currency: cmd.Currency,
+		})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("could not close product: %w", err)
+	}
+	printProducts(os.Stdout, closed, created)
+	return nil
+}
+
+func (cmd *productsCommand) delete(cliCtx *cli.Context) error {
+	rdb, err := openDB(cliCtx, productsCommandName, cmd.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer rdb.Close()
+
+	if err := db.DeleteProduct(cliCtx.Context, rdb, cmd.ID); err != nil {
+		return fmt.Errorf("could not delete product: %w", err)
+	}
+	AppLogger(cliCtx.Context).WithName(productsCommandName).Info("Deleted product", "id", cmd.ID)
+	return nil
+}
+
+func printProducts(out io.Writer, products ...db.Product) {
+	printTable(out, "ID\tSource\tTarget\tAmount\tCurrency\tUnit\tDuring", products, func(p db.Product) []interface{} {
+		return []interface{}{p.Id, p.Source, p.Target.String, p.Amount, p.Currency, p.Unit, db.FormatTimerange(p.During)}
+	})
+}
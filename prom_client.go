@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/prometheus/client_golang/api"
+	apiv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/config"
+	"github.com/urfave/cli/v2"
+)
+
+// promClientFlags holds the flags configuring the connection to prometheus.
+type promClientFlags struct {
+	URL                   string
+	Strict                bool
+	BearerToken           string
+	BearerTokenFile       string
+	BasicAuthUsername     string
+	BasicAuthPassword     string
+	BasicAuthPasswordFile string
+	CAFile                string
+	CertFile              string
+	KeyFile               string
+	ProxyURL              string
+}
+
+func (f *promClientFlags) flags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{Name: "prom-url", Usage: "Prometheus connection URL in the form of http://host:port",
+			EnvVars: envVars("PROM_URL"), Destination: &f.URL, Value: "http://localhost:9090"},
+		&cli.BoolFlag{Name: "prom-strict", Usage: "Disable partial responses of Thanos by sending partial_response=false",
+			EnvVars: envVars("PROM_STRICT"), Destination: &f.Strict},
+		&cli.StringFlag{Name: "prom-bearer-token", Usage: "Bearer token sent to prometheus",
+			EnvVars: envVars("PROM_BEARER_TOKEN"), Destination: &f.BearerToken},
+		&cli.StringFlag{Name: "prom-bearer-token-file", Usage: "File containing the bearer token sent to prometheus, read on every request",
+			EnvVars: envVars("PROM_BEARER_TOKEN_FILE"), Destination: &f.BearerTokenFile},
+		&cli.StringFlag{Name: "prom-basic-auth-username", Usage: "Username for basic authentication against prometheus",
+			EnvVars: envVars("PROM_BASIC_AUTH_USERNAME"), Destination: &f.BasicAuthUsername},
+		&cli.StringFlag{Name: "prom-basic-auth-password", Usage: "Password for basic authentication against prometheus",
+			EnvVars: envVars("PROM_BASIC_AUTH_PASSWORD"), Destination: &f.BasicAuthPassword},
+		&cli.StringFlag{Name: "prom-basic-auth-password-file", Usage: "File containing the password for basic authentication against prometheus, read on every request",
+			EnvVars: envVars("PROM_BASIC_AUTH_PASSWORD_FILE"), Destination: &f.BasicAuthPasswordFile},
+		&cli.StringFlag{Name: "prom-ca-file", Usage: "CA bundle used to verify the certificate of prometheus",
+			EnvVars: envVars("PROM_CA_FILE"), Destination: &f.CAFile},
+		&cli.StringFlag{Name: "prom-cert-file", Usage: "Client certificate used to authenticate against prometheus",
+			EnvVars: envVars("PROM_CERT_FILE"), Destination: &f.CertFile},
+		&cli.StringFlag{Name: "prom-key-file", Usage: "Key of the client certificate used to authenticate against prometheus",
+			EnvVars: envVars("PROM_KEY_FILE"), Destination: &f.KeyFile},
+		&cli.StringSliceFlag{Name: "prom-header", Usage: "HTTP header sent to prometheus in the form of Name=Value, can be repeated (example: X-Scope-OrgID=tenant)",
+			EnvVars: envVars("PROM_HEADER")},
+		&cli.StringFlag{Name: "prom-proxy-url", Usage: "HTTP proxy used to connect to prometheus in the form of http://host:port",
+			EnvVars: envVars("PROM_PROXY_URL"), Destination: &f.ProxyURL},
+	}
+}
+
+// httpClientConfig returns the prometheus HTTP client configuration for the flags.
+func (f *promClientFlags) httpClientConfig() (config.HTTPClientConfig, error) {
+	cfg := config.DefaultHTTPClientConfig
+	if f.BearerToken != "" || f.BearerTokenFile != "" {
+		cfg.Authorization = &config.Authorization{
+			Type:            "Bearer",
+			Credentials:     config.Secret(f.BearerToken),
+			CredentialsFile: f.BearerTokenFile,
+		}
+	}
+	if f.BasicAuthUsername != "" {
+		cfg.BasicAuth = &config.BasicAuth{
+			Username:     f.BasicAuthUsername,
+			Password:     config.Secret(f.BasicAuthPassword),
+			PasswordFile: f.BasicAuthPasswordFile,
+		}
+	} else if f.BasicAuthPassword != "" || f.BasicAuthPasswordFile != "" {
+		return cfg, fmt.Errorf("basic authentication password requires a username")
+	}
+	cfg.TLSConfig = config.TLSConfig{
+		CAFile:   f.CAFile,
+		CertFile: f.CertFile,
+		KeyFile:  f.KeyFile,
+	}
+	if f.ProxyURL != "" {
+		proxyURL, err := url.Parse(f.ProxyURL)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid proxy url: %w", err)
+		}
+		cfg.ProxyURL = config.URL{URL: proxyURL}
+	}
+	return cfg, cfg.Validate()
+}
+
+// parseHeaders parses headers in the form of Name=Value.
+func parseHeaders(raw []string) (http.Header, error) {
+	headers := http.Header{}
+	for _, h := range raw {
+		name, value, ok := strings.Cut(h, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid header %q, expected Name=Value", h)
+		}
+		headers.Add(name, strings.TrimSpace(value))
+	}
+	return headers, nil
+}
+
+func newPrometheusAPIClient(cliCtx *cli.Context, f promClientFlags) (apiv1.API, error) {
+	cfg, err := f.httpClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	headers, err := parseHeaders(cliCtx.StringSlice("prom-header"))
+	if err != nil {
+		return nil, err
+	}
+	rt, err := config.NewRoundTripperFromConfig(cfg, "appuio-cloud-reporting")
+	if err != nil {
+		return nil, err
+	}
+	if len(headers) > 0 {
+		rt = headerRoundTripper{headers: headers, next: rt}
+	}
+
+	client, err := api.NewClient(api.Config{
+		Address:      f.URL,
+		RoundTripper: rt,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if f.Strict {
+		client = strictClient{client}
+	}
+	return apiv1.NewAPI(client), nil
+}
+
+// headerRoundTripper adds the given headers to every request.
+type headerRoundTripper struct {
+	headers http.Header
+	next    http.RoundTripper
+}
+
+func (rt headerRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTrippers must not modify the original request
+	req = req.Clone(req.Context())
+	for name, values := range rt.headers {
+		req.Header.Del(name)
+		for _, v := range values {
+			req.Header.Add(name, v)
+		}
+	}
+	return rt.next.RoundTrip(req)
+}
+
+// strictClient disables partial responses of Thanos for every request.
+// Thanos fails the query instead of returning a warning if a store is not available.
+type strictClient struct {
+	api.Client
+}
+
+func (c strictClient) Do(ctx context.Context, req *http.Request) (*http.Response, []byte, error) {
+	q := req.URL.Query()
+	q.Set("partial_response", "false")
+	req.URL.RawQuery = q.Encode()
+	return c.Client.Do(ctx, req)
+}
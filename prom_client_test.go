@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+func TestPrometheusAPIClient_Authentication(t *testing.T) {
+	var auth, orgID, partial []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		auth = append(auth, r.Header.Get("Authorization"))
+		orgID = append(orgID, r.Header.Get("X-Scope-OrgID"))
+		partial = append(partial, r.Form.Get("partial_response"))
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[]}}`))
+	}))
+	defer srv.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("first\n"), 0o600))
+
+	var flags promClientFlags
+	app := &cli.App{
+		Flags: flags.flags(),
+		Action: func(cliCtx *cli.Context) error {
+			client, err := newPrometheusAPIClient(cliCtx, flags)
+			require.NoError(t, err)
+
+			_, _, err = client.Query(context.Background(), "up", time.Now())
+			require.NoError(t, err)
+			require.NoError(t, os.WriteFile(tokenFile, []byte("second\n"), 0o600))
+			_, _, err = client.Query(context.Background(), "up", time.Now())
+			return err
+		},
+	}
+	require.NoError(t, app.Run([]string{"test", "--prom-url", srv.URL, "--prom-strict",
+		"--prom-bearer-token-file", tokenFile, "--prom-header", "X-Scope-OrgID=tenant-a"}))
+
+	assert.Equal(t, []string{"Bearer first", "Bearer second"}, auth, "token file should be read on every request")
+	assert.Equal(t, []string{"tenant-a", "tenant-a"}, orgID)
+	assert.Equal(t, []string{"false", "false"}, partial)
+}
+
+func TestPromClientFlags_HTTPClientConfig(t *testing.T) {
+	_, err := (&promClientFlags{BearerToken: "a", BearerTokenFile: "b"}).httpClientConfig()
+	assert.Error(t, err, "token and token file are mutually exclusive")
+	_, err = (&promClientFlags{BearerToken: "a", BasicAuthUsername: "b"}).httpClientConfig()
+	assert.Error(t, err, "bearer token and basic auth are mutually exclusive")
+	_, err = (&promClientFlags{BasicAuthPassword: "a"}).httpClientConfig()
+	assert.Error(t, err, "password requires a username")
+
+	cfg, err := (&promClientFlags{BasicAuthUsername: "a", BasicAuthPassword: "b", ProxyURL: "http://proxy:3128"}).httpClientConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "a", cfg.BasicAuth.Username)
+	assert.Equal(t, "proxy:3128", cfg.ProxyURL.Host)
+}
+
+func TestParseHeaders(t *testing.T) {
+	h, err := parseHeaders([]string{"X-Scope-OrgID=tenant-a", "X-Test = a=b"})
+	require.NoError(t, err)
+	assert.Equal(t, "tenant-a", h.Get("X-Scope-OrgID"))
+	assert.Equal(t, "a=b", h.Get("X-Test"))
+
+	_, err = parseHeaders([]string{"X-Scope-OrgID"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/urfave/cli/v2"
+
+	"github.com/appuio/appuio-cloud-reporting/pkg/check"
+	"github.com/appuio/appuio-cloud-reporting/pkg/db"
+)
+
+type queriesCommand struct {
+	DatabaseURL string
+	File        string
+	At          *time.Time
+}
+
+var queriesCommandName = "queries"
+
+func newQueriesCommand() *cli.Command {
+	command := &queriesCommand{}
+	return &cli.Command{
+		Name:  queriesCommandName,
+		Usage: "Manage queries and their validity",
+		Subcommands: []*cli.Command{
+			{
+				Name:   "list",
+				Usage:  "List queries and sub-queries",
+				Before: command.before,
+				Action: command.list,
+				Flags: []cli.Flag{
+					newDbURLFlag(&command.DatabaseURL),
+					newAtFlag(false, "Only list queries valid at this timestamp"),
+				},
+			},
+			{
+				Name:      "show",
+				Usage:     "Show the query with the given name valid at the given timestamp and its sub-queries",
+				ArgsUsage: "<name>",
+				Before:    command.before,
+				Action:    command.show,
+				Flags: []cli.Flag{
+					newDbURLFlag(&command.DatabaseURL),
+					newAtFlag(false, "Show the version of the query valid at this timestamp (default: now)"),
+				},
+			},
+			{
+				Name:      "rollover",
+				Usage:     "End the validity of the current version of the query at the given timestamp and create a new version with its sub-queries starting at that timestamp",
+				ArgsUsage: "<name>",
+				Before:    command.before,
+				Action:    command.rollover,
+				Flags: []cli.Flag{
+					newDbURLFlag(&command.DatabaseURL),
+					newAtFlag(true, "Timestamp at which the current version ends and the new one starts"),
+					&cli.StringFlag{Name: "file", Aliases: []string{"f"}, Usage: "File containing the PromQL of the new version, - reads from stdin",
+						Destination: &command.File, Required: true, DefaultText: defaultTestForRequiredFlags},
+				},
+			},
+			{
+				Name:   "validate",
+				Usage:  "Check the PromQL, the produced labels and the sub-query links of all queries",
+				Before: command.before,
+				Action: command.validate,
+				Flags: []cli.Flag{
+					newDbURLFlag(&command.DatabaseURL),
+					newAtFlag(false, "Render query templates with the parameters valid at this timestamp (default: now)"),
+				},
+			},
+		},
+	}
+}
+
+func (cmd *queriesCommand) before(context *cli.Context) error {
+	cmd.At = context.Timestamp("at")
+	return LogMetadata(context)
+}
+
+// nameArg returns the query name given as the only argument.
+func nameArg(cliCtx *cli.Context) (string, error) {
+	if cliCtx.NArg() != 1 {
+		return "", fmt.Errorf("expected exactly one query name as argument, got %d arguments", cliCtx.NArg())
+	}
+	return cliCtx.Args().First(), nil
+}
+
+func (cmd *queriesCommand) list(cliCtx *cli.Context) error {
+	rdb, err := openDB(cliCtx, queriesCommandName, cmd.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer rdb.Close()
+
+	queries, err := db.ListQueries(cliCtx.Context, rdb, cmd.At)
+	if err != nil {
+		return err
+	}
+	printQueries(os.Stdout, queries...)
+	return nil
+}
+
+func (cmd *queriesCommand) show(cliCtx *cli.Context) error {
+	name, err := nameArg(cliCtx)
+	if err != nil {
+		return err
+	}
+	at := time.Now()
+	if cmd.At != nil {
+		at = *cmd.At
+	}
+
+	rdb, err := openDB(cliCtx, queriesCommandName, cmd.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer rdb.Close()
+
+	query, err := db.GetQueryAt(cliCtx.Context, rdb, name, at)
+	if err != nil {
+		return err
+	}
+	subQueries, err := db.ListSubQueries(cliCtx.Context, rdb, query.Id, &at)
+	if err != nil {
+		return err
+	}
+
+	queries := append([]db.Query{query}, subQueries...)
+	printQueries(os.Stdout, queries...)
+	for _, q := range queries {
+		fmt.Fprintf(os.Stdout, "\n# %s (%s)\n%s\n", q.Name, q.Id, strings.TrimSpace(q.Query))
+	}
+	return nil
+}
+
+func (cmd *queriesCommand) rollover(cliCtx *cli.Context) error {
+	name, err := nameArg(cliCtx)
+	if err != nil {
+		return err
+	}
+	promQL, err := cmd.readFile()
+	if err != nil {
+		return err
+	}
+
+	rdb, err := openDB(cliCtx, queriesCommandName, cmd.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer rdb.Close()
+
+	var closed, created db.Query
+	var carried []db.Query
+	err = db.RunInTransaction(cliCtx.Context, rdb, func(tx *sqlx.Tx) error {
+		closed, created, carried, err = db.RolloverQuery(cliCtx.Context, tx, name, *cmd.At, promQL)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("could not roll over query: %w", err)
+	}
+	printQueries(os.Stdout, append([]db.Query{closed, created}, carried...)...)
+	return nil
+}
+
+func (cmd *queriesCommand) readFile() (string, error) {
+	var r io.Reader = os.Stdin
+	if cmd.File != "-" {
+		f, err := os.Open(cmd.File)
+		if err != nil {
+			return "", fmt.Errorf("could not open query: %w", err)
+		}
+		defer f.Close()
+		r = f
+	}
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return "", fmt.Errorf("could not read query: %w", err)
+	}
+	return string(raw), nil
+}
+
+func (cmd *queriesCommand) validate(cliCtx *cli.Context) error {
+	ctx := cliCtx.Context
+	log := AppLogger(ctx).WithName(queriesCommandName)
+
+	rdb, err := openDB(cliCtx, queriesCommandName, cmd.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer rdb.Close()
+
+	log.V(1).Info("Begin transaction")
+	tx, err := rdb.BeginTxx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	at := time.Now()
+	if cmd.At != nil {
+		at = *cmd.At
+	}
+	issues, err := check.Queries(ctx, tx, at)
+	if err != nil {
+		return err
+	}
+
+	if len(issues) == 0 {
+		return nil
+	}
+
+	printTable(os.Stdout, "Query\tDuring\tIssue", issues, func(i check.QueryIssue) []interface{} {
+		return []interface{}{i.Query, i.During, i.Issue}
+	})
+	return cli.Exit(fmt.Sprintf("%d issues found.", len(issues)), 1)
+}
+
+func printQueries(out io.Writer, queries ...db.Query) {
+	printTable(out, "ID\tParent\tName\tUnit\tMode\tDuring", queries, func(q db.Query) []interface{} {
+		return []interface{}{q.Id, q.ParentID.String, q.Name, q.Unit, q.Mode, db.FormatTimerange(q.During)}
+	})
+}
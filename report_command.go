@@ -9,18 +9,25 @@ import (
 	"github.com/appuio/appuio-cloud-reporting/pkg/db"
 	"github.com/appuio/appuio-cloud-reporting/pkg/report"
 	"github.com/jmoiron/sqlx"
-	"github.com/prometheus/client_golang/api"
 	apiv1 "github.com/prometheus/client_golang/api/prometheus/v1"
 	"github.com/urfave/cli/v2"
 )
 
 type reportCommand struct {
 	DatabaseURL      string
-	PrometheusURL    string
-	QueryName        string
+	QueryNames       []string
+	AllQueries       bool
 	Begin            *time.Time
 	RepeatUntil      *time.Time
 	PromQueryTimeout time.Duration
+	DryRun           bool
+	UnresolvedPolicy string
+	Parallelism      int
+	PromWarnings     string
+	Prom             promClientFlags
+	Retry            promRetryFlags
+	From             *time.Time
+	To               *time.Time
 }
 
 var reportCommandName = "report"
@@ -29,27 +36,56 @@ func newReportCommand() *cli.Command {
 	command := &reportCommand{}
 	return &cli.Command{
 		Name:   reportCommandName,
-		Usage:  "Run a report for a query in the given period",
+		Usage:  "Run a report for one or more queries in the given period",
 		Before: command.before,
 		Action: command.execute,
-		Flags: []cli.Flag{
+		// Subcommands use the database of the report command, required flags of a command are checked before running its subcommands.
+		Subcommands: []*cli.Command{
+			{
+				Name:   "replay-quarantine",
+				Usage:  "Replay quarantined samples which could not be resolved to a product or discount",
+				Action: command.replayQuarantine,
+			},
+			{
+				Name:   "status",
+				Usage:  "Show failed and missing report runs of every query in the given period",
+				Before: command.beforeStatus,
+				Action: command.status,
+				Flags: []cli.Flag{
+					&cli.TimestampFlag{Name: "from", Usage: fmt.Sprintf("Beginning of the period (%s)", time.RFC3339),
+						Layout: time.RFC3339, Required: true, DefaultText: defaultTestForRequiredFlags},
+					&cli.TimestampFlag{Name: "to", Usage: fmt.Sprintf("End of the period, exclusive (%s)", time.RFC3339),
+						Layout: time.RFC3339, Required: true, DefaultText: defaultTestForRequiredFlags},
+				},
+			},
+		},
+		Flags: append([]cli.Flag{
 			newDbURLFlag(&command.DatabaseURL),
-			newPromURLFlag(&command.PrometheusURL),
-			&cli.StringFlag{Name: "query-name", Usage: fmt.Sprintf("Name of the query (sample values: %s)", queryNames(db.DefaultQueries)),
-				EnvVars: envVars("QUERY_NAME"), Destination: &command.QueryName, Required: true, DefaultText: defaultTestForRequiredFlags},
-			&cli.TimestampFlag{Name: "begin", Usage: fmt.Sprintf("Beginning timestamp of the report period in the form of RFC3339 (%s)", time.RFC3339),
-				EnvVars: envVars("BEGIN"), Layout: time.RFC3339, Required: true, DefaultText: defaultTestForRequiredFlags},
+			newPromWarningsFlag(&command.PromWarnings),
+			&cli.StringSliceFlag{Name: "query-name", Usage: fmt.Sprintf("Name of the query, can be repeated (sample values: %s)", queryNames(db.DefaultQueries)),
+				EnvVars: envVars("QUERY_NAME")},
+			&cli.BoolFlag{Name: "all-queries", Usage: "Run all top-level queries valid at the report timestamps instead of --query-name",
+				EnvVars: envVars("ALL_QUERIES"), Destination: &command.AllQueries},
+			&cli.TimestampFlag{Name: "begin", Usage: fmt.Sprintf("Beginning timestamp of the report period in the form of RFC3339 (%s), required to run reports", time.RFC3339),
+				EnvVars: envVars("BEGIN"), Layout: time.RFC3339, DefaultText: "none"},
 			&cli.TimestampFlag{Name: "repeat-until", Usage: fmt.Sprintf("Repeat running the report until reaching this timestamp (%s)", time.RFC3339),
-				EnvVars: envVars("REPEAT_UNTIL"), Layout: time.RFC3339, Required: false},
+				EnvVars: envVars("REPEAT_UNTIL"), Layout: time.RFC3339, Required: false, DefaultText: "one hour after begin"},
 			&cli.DurationFlag{Name: "prom-query-timeout", Usage: "Timeout when querying prometheus (example: 1m)",
 				EnvVars: envVars("PROM_QUERY_TIMEOUT"), Destination: &command.PromQueryTimeout, Required: false},
-		},
+			&cli.BoolFlag{Name: "dry-run", Usage: "Print the resolved samples and roll back instead of saving the facts",
+				EnvVars: envVars("DRY_RUN"), Destination: &command.DryRun},
+			&cli.StringFlag{Name: "unresolved-samples", Usage: fmt.Sprintf("How to handle samples without a matching product or discount (values: %v)", report.UnresolvedSamplePolicies),
+				EnvVars: envVars("UNRESOLVED_SAMPLES"), Destination: &command.UnresolvedPolicy, Value: string(report.FailOnUnresolvedSample)},
+			&cli.IntFlag{Name: "parallelism", Usage: "Number of hours to run concurrently when repeating the report",
+				EnvVars: envVars("PARALLELISM"), Destination: &command.Parallelism, Value: 1},
+		}, append(command.Prom.flags(), command.Retry.flags()...)...),
 	}
 }
 
 func (cmd *reportCommand) before(context *cli.Context) error {
 	cmd.Begin = context.Timestamp("begin")
 	cmd.RepeatUntil = context.Timestamp("repeat-until")
+	cmd.QueryNames = context.StringSlice("query-name")
 	return LogMetadata(context)
 }
 
@@ -57,29 +93,52 @@ func (cmd *reportCommand) execute(cliCtx *cli.Context) error {
 	ctx := cliCtx.Context
 	log := AppLogger(ctx).WithName(reportCommandName)
 
-	promClient, err := newPrometheusAPIClient(cmd.PrometheusURL)
+	// Begin is only required to run reports, not for the subcommands
+	if !cliCtx.IsSet("begin") {
+		return fmt.Errorf("required flag %q not set", "begin")
+	}
+	if len(cmd.QueryNames) == 0 && !cmd.AllQueries {
+		return fmt.Errorf("required flag \"query-name\" or \"all-queries\" not set")
+	}
+	if len(cmd.QueryNames) > 0 && cmd.AllQueries {
+		return fmt.Errorf("flags \"query-name\" and \"all-queries\" can't be used together")
+	}
+	policy, err := parseUnresolvedSamplePolicy(cmd.UnresolvedPolicy)
+	if err != nil {
+		return err
+	}
+	warningsPolicy, err := parseWarningsPolicy(cmd.PromWarnings)
+	if err != nil {
+		return err
+	}
+	retryPolicy, err := cmd.Retry.policy(cliCtx)
+	if err != nil {
+		return err
+	}
+
+	promClient, err := newPrometheusAPIClient(cliCtx, cmd.Prom)
 	if err != nil {
 		return fmt.Errorf("could not create prometheus client: %w", err)
 	}
 
-	log.V(1).Info("Opening database connection", "url", cmd.DatabaseURL)
-	rdb, err := db.Openx(cmd.DatabaseURL)
+	rdb, err := openDB(cliCtx, reportCommandName, cmd.DatabaseURL)
 	if err != nil {
-		return fmt.Errorf("could not open database connection: %w", err)
+		return err
 	}
 	defer rdb.Close()
 
-	o := make([]report.Option, 0)
+	o := []report.Option{report.WithUnresolvedSamplePolicy(policy), report.WithVersion(version),
+		report.WithWarningsPolicy(warningsPolicy), report.WithLogger(log), report.WithRetryPolicy(retryPolicy)}
 	if cmd.PromQueryTimeout != 0 {
 		o = append(o, report.WithPrometheusQueryTimeout(cmd.PromQueryTimeout))
 	}
 
-	if cmd.RepeatUntil != nil {
-		if err := cmd.runReportRange(ctx, rdb, promClient, o); err != nil {
+	if cmd.DryRun {
+		if err := cmd.runDryRun(ctx, rdb, promClient, o); err != nil {
 			return err
 		}
 	} else {
-		if err := cmd.runReport(ctx, rdb, promClient, o); err != nil {
+		if err := cmd.runReports(ctx, rdb, promClient, o); err != nil {
 			return err
 		}
 	}
@@ -88,7 +147,8 @@ func (cmd *reportCommand) execute(cliCtx *cli.Context) error {
 	return nil
 }
 
-func (cmd *reportCommand) runReportRange(ctx context.Context, db *sqlx.DB, promClient apiv1.API, o []report.Option) error {
+// runReports runs the reports for all queries and prints a summary for every query.
+func (cmd *reportCommand) runReports(ctx context.Context, db *sqlx.DB, promClient apiv1.API, o []report.Option) error {
 	log := AppLogger(ctx)
 
 	started := time.Now()
@@ -99,12 +159,44 @@ func (cmd *reportCommand) runReportRange(ctx context.Context, db *sqlx.DB, promC
 	})
 
 	log.Info("Running reports...")
-	c, err := report.RunRange(ctx, db, promClient, cmd.QueryName, *cmd.Begin, *cmd.RepeatUntil, append(o, reporter)...)
-	log.Info(fmt.Sprintf("Ran %d reports", c))
-	return err
+	summaries, err := report.RunQueries(ctx, db, promClient, cmd.QueryNames, *cmd.Begin, cmd.until(), append(o, reporter, report.WithParallelism(cmd.Parallelism))...)
+	if err != nil {
+		return err
+	}
+
+	printTable(os.Stdout, "QUERY\tREPORTS\tSAMPLES\tFAILED", summaries, func(s report.QuerySummary) []interface{} {
+		return []interface{}{s.Query, s.Reports, s.Samples, len(s.Failed)}
+	})
+	reports, failed := 0, 0
+	for _, s := range summaries {
+		reports += s.Reports
+		failed += len(s.Failed)
+	}
+	log.Info(fmt.Sprintf("Ran %d reports", reports))
+
+	for _, s := range summaries {
+		for _, f := range s.Failed {
+			log.Error(f.Err, "Report failed", "query", s.Query, "timestamp", f.Timestamp.Format(time.RFC3339))
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d reports failed", failed, reports)
+	}
+	return nil
 }
 
-func (cmd *reportCommand) runReport(ctx context.Context, db *sqlx.DB, promClient apiv1.API, o []report.Option) error {
+// until returns the end of the report period.
+// Only the hour starting at begin is reported if repeat-until is not set.
+func (cmd *reportCommand) until() time.Time {
+	if cmd.RepeatUntil != nil {
+		return *cmd.RepeatUntil
+	}
+	return cmd.Begin.Add(time.Hour)
+}
+
+// runDryRun runs the reports for the whole period in a single transaction and prints the resolved samples.
+// The transaction is always rolled back.
+func (cmd *reportCommand) runDryRun(ctx context.Context, db *sqlx.DB, promClient apiv1.API, o []report.Option) error {
 	log := AppLogger(ctx)
 
 	log.V(1).Info("Begin transaction")
@@ -114,18 +206,117 @@ func (cmd *reportCommand) runReport(ctx context.Context, db *sqlx.DB, promClient
 	}
 	defer tx.Rollback()
 
-	log.Info("Running report...")
-	if err := report.Run(ctx, tx, promClient, cmd.QueryName, *cmd.Begin, o...); err != nil {
+	var samples []report.ResolvedSample
+	reporter := report.WithSampleReporter(func(s report.ResolvedSample) {
+		samples = append(samples, s)
+	})
+	// Print the samples resolved so far even if a report fails
+	defer func() {
+		printTable(os.Stdout, "TIMESTAMP\tQUERY\tSOURCE KEY\tPRODUCT\tDISCOUNT\tQUANTITY\tPRICE", samples, func(s report.ResolvedSample) []interface{} {
+			return []interface{}{s.Timestamp.Format(time.RFC3339), s.Query, s.SourceKey, s.Product, s.Discount, s.Quantity, s.Price}
+		})
+	}()
+
+	log.Info("Running reports in dry-run mode...")
+	for ts := *cmd.Begin; cmd.until().After(ts); ts = ts.Add(time.Hour) {
+		names := cmd.QueryNames
+		if cmd.AllQueries {
+			if names, err = report.QueryNamesAt(ctx, tx, ts); err != nil {
+				return err
+			}
+		}
+		for _, name := range names {
+			if err := report.Run(ctx, tx, promClient, name, ts, append(o, reporter)...); err != nil {
+				return fmt.Errorf("error running report for %s at %s: %w", name, ts.Format(time.RFC3339), err)
+			}
+		}
+	}
+
+	log.V(1).Info("Rollback transaction")
+	return nil
+}
+
+func (cmd *reportCommand) replayQuarantine(cliCtx *cli.Context) error {
+	ctx := cliCtx.Context
+	log := AppLogger(ctx).WithName(reportCommandName)
+
+	rdb, err := openDB(cliCtx, reportCommandName, cmd.DatabaseURL)
+	if err != nil {
 		return err
 	}
+	defer rdb.Close()
 
-	log.V(1).Info("Commit transaction")
-	return tx.Commit()
+	log.Info("Replaying quarantined samples...")
+	return db.RunInTransaction(ctx, rdb, func(tx *sqlx.Tx) error {
+		replayed, unresolved, err := report.ReplayQuarantine(ctx, tx)
+		if err != nil {
+			return err
+		}
+		log.Info("Replayed quarantined samples", "replayed", replayed, "unresolved", unresolved)
+		return nil
+	})
 }
 
-func newPrometheusAPIClient(promURL string) (apiv1.API, error) {
-	client, err := api.NewClient(api.Config{
-		Address: promURL,
+func (cmd *reportCommand) beforeStatus(context *cli.Context) error {
+	cmd.From = context.Timestamp("from")
+	cmd.To = context.Timestamp("to")
+	return nil
+}
+
+func (cmd *reportCommand) status(cliCtx *cli.Context) error {
+	ctx := cliCtx.Context
+
+	rdb, err := openDB(cliCtx, reportCommandName, cmd.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer rdb.Close()
+
+	statuses, err := report.Status(ctx, rdb, *cmd.From, *cmd.To)
+	if err != nil {
+		return err
+	}
+
+	printTable(os.Stdout, "QUERY\tHOURS\tSUCCEEDED\tFAILED\tMISSING", statuses, func(s report.QueryStatus) []interface{} {
+		return []interface{}{s.Query, len(s.Hours), s.Count(report.RunSucceeded), s.Count(report.RunFailed), s.Count(report.RunMissing)}
 	})
-	return apiv1.NewAPI(client), err
+
+	type incompleteHour struct {
+		query string
+		report.HourStatus
+	}
+	var incomplete []incompleteHour
+	for _, s := range statuses {
+		for _, h := range s.Hours {
+			if h.Status != report.RunSucceeded {
+				incomplete = append(incomplete, incompleteHour{query: s.Query, HourStatus: h})
+			}
+		}
+	}
+	if len(incomplete) == 0 {
+		return nil
+	}
+	fmt.Println()
+	printTable(os.Stdout, "QUERY\tTIMESTAMP\tSTATUS\tERROR", incomplete, func(h incompleteHour) []interface{} {
+		return []interface{}{h.query, h.Timestamp.Format(time.RFC3339), h.Status, h.Error}
+	})
+	return cli.Exit(fmt.Sprintf("%d hours failed or are missing.", len(incomplete)), 1)
+}
+
+func parseUnresolvedSamplePolicy(raw string) (report.UnresolvedSamplePolicy, error) {
+	for _, p := range report.UnresolvedSamplePolicies {
+		if string(p) == raw {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("unknown unresolved sample policy %q (values: %v)", raw, report.UnresolvedSamplePolicies)
+}
+
+func parseWarningsPolicy(raw string) (report.WarningsPolicy, error) {
+	for _, p := range report.WarningsPolicies {
+		if string(p) == raw {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("unknown warnings policy %q (values: %v)", raw, report.WarningsPolicies)
 }
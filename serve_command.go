@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/appuio/appuio-cloud-reporting/pkg/report"
+	"github.com/appuio/appuio-cloud-reporting/pkg/schedule"
+)
+
+type serveCommand struct {
+	DatabaseURL      string
+	Delay            time.Duration
+	Begin            *time.Time
+	GiveUpAfter      time.Duration
+	PromQueryTimeout time.Duration
+	UnresolvedPolicy string
+	Parallelism      int
+	PromWarnings     string
+	Prom             promClientFlags
+	Retry            promRetryFlags
+}
+
+var serveCommandName = "serve"
+
+func newServeCommand() *cli.Command {
+	command := &serveCommand{}
+	return &cli.Command{
+		Name:   serveCommandName,
+		Usage:  "Run the reports for all queries every hour and catch up on missed hours",
+		Before: command.before,
+		Action: command.execute,
+		Flags: append([]cli.Flag{
+			newDbURLFlag(&command.DatabaseURL),
+			newPromWarningsFlag(&command.PromWarnings),
+			&cli.DurationFlag{Name: "delay", Usage: "Time to wait after the end of an hour before running its reports",
+				EnvVars: envVars("DELAY"), Destination: &command.Delay, Value: 10 * time.Minute},
+			&cli.TimestampFlag{Name: "begin", Usage: fmt.Sprintf("First hour to report if no hour was reported successfully yet, defaults to the last complete hour (%s)", time.RFC3339),
+				EnvVars: envVars("BEGIN"), Layout: time.RFC3339, DefaultText: "not set"},
+			&cli.DurationFlag{Name: "give-up-after", Usage: "Time failed hours are retried for, older failed hours are skipped and stay recorded as failed",
+				EnvVars: envVars("GIVE_UP_AFTER"), Destination: &command.GiveUpAfter, Value: schedule.DefaultGiveUpAfter},
+			&cli.DurationFlag{Name: "prom-query-timeout", Usage: "Timeout when querying prometheus (example: 1m)",
+				EnvVars: envVars("PROM_QUERY_TIMEOUT"), Destination: &command.PromQueryTimeout},
+			&cli.StringFlag{Name: "unresolved-samples", Usage: fmt.Sprintf("How to handle samples without a matching product or discount (values: %v)", report.UnresolvedSamplePolicies),
+				EnvVars: envVars("UNRESOLVED_SAMPLES"), Destination: &command.UnresolvedPolicy, Value: string(report.FailOnUnresolvedSample)},
+			&cli.IntFlag{Name: "parallelism", Usage: "Number of reports to run concurrently when catching up",
+				EnvVars: envVars("PARALLELISM"), Destination: &command.Parallelism, Value: 1},
+		}, append(command.Prom.flags(), command.Retry.flags()...)...),
+	}
+}
+
+func (cmd *serveCommand) before(context *cli.Context) error {
+	cmd.Begin = context.Timestamp("begin")
+	return LogMetadata(context)
+}
+
+func (cmd *serveCommand) execute(cliCtx *cli.Context) error {
+	ctx := cliCtx.Context
+	log := AppLogger(ctx).WithName(serveCommandName)
+
+	policy, err := parseUnresolvedSamplePolicy(cmd.UnresolvedPolicy)
+	if err != nil {
+		return err
+	}
+	warningsPolicy, err := parseWarningsPolicy(cmd.PromWarnings)
+	if err != nil {
+		return err
+	}
+	retryPolicy, err := cmd.Retry.policy(cliCtx)
+	if err != nil {
+		return err
+	}
+
+	promClient, err := newPrometheusAPIClient(cliCtx, cmd.Prom)
+	if err != nil {
+		return fmt.Errorf("could not create prometheus client: %w", err)
+	}
+
+	rdb, err := openDB(cliCtx, serveCommandName, cmd.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer rdb.Close()
+
+	o := []report.Option{report.WithUnresolvedSamplePolicy(policy), report.WithParallelism(cmd.Parallelism), report.WithVersion(version),
+		report.WithWarningsPolicy(warningsPolicy), report.WithLogger(log), report.WithRetryPolicy(retryPolicy)}
+	if cmd.PromQueryTimeout != 0 {
+		o = append(o, report.WithPrometheusQueryTimeout(cmd.PromQueryTimeout))
+	}
+
+	scheduler := &schedule.Scheduler{
+		DB:          rdb,
+		Prom:        promClient,
+		Log:         log,
+		Delay:       cmd.Delay,
+		GiveUpAfter: cmd.GiveUpAfter,
+		Options:     o,
+	}
+	if cmd.Begin != nil {
+		scheduler.Begin = *cmd.Begin
+	}
+
+	log.Info("Starting scheduler", "delay", cmd.Delay)
+	return scheduler.Run(ctx)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/urfave/cli/v2"
+
+	"github.com/appuio/appuio-cloud-reporting/pkg/db"
+)
+
+type tenantsCommand struct {
+	DatabaseURL string
+	Source      string
+	Currency    string
+}
+
+var tenantsCommandName = "tenants"
+
+func newTenantsCommand() *cli.Command {
+	command := &tenantsCommand{}
+	return &cli.Command{
+		Name:  tenantsCommandName,
+		Usage: "Manage tenants created by reports",
+		Subcommands: []*cli.Command{
+			{
+				Name:   "list",
+				Usage:  "List tenants",
+				Before: LogMetadata,
+				Action: command.list,
+				Flags: []cli.Flag{
+					newDbURLFlag(&command.DatabaseURL),
+				},
+			},
+			{
+				Name:   "set-currency",
+				Usage:  "Set the currency invoices of a tenant are generated in",
+				Before: LogMetadata,
+				Action: command.setCurrency,
+				Flags: []cli.Flag{
+					newDbURLFlag(&command.DatabaseURL),
+					&cli.StringFlag{Name: "source", Usage: "Source of the tenant (example: acme-corp)",
+						Destination: &command.Source, Required: true, DefaultText: defaultTestForRequiredFlags},
+					&cli.StringFlag{Name: "currency", Usage: "ISO 4217 code of the billing currency (example: EUR)",
+						Destination: &command.Currency, Required: true, DefaultText: defaultTestForRequiredFlags},
+				},
+			},
+		},
+	}
+}
+
+func (cmd *tenantsCommand) list(cliCtx *cli.Context) error {
+	rdb, err := openDB(cliCtx, tenantsCommandName, cmd.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer rdb.Close()
+
+	tenants, err := db.ListTenants(cliCtx.Context, rdb)
+	if err != nil {
+		return err
+	}
+	printTenants(os.Stdout, tenants...)
+	return nil
+}
+
+func (cmd *tenantsCommand) setCurrency(cliCtx *cli.Context) error {
+	if err := db.ValidateCurrency(cmd.Currency); err != nil {
+		return err
+	}
+
+	rdb, err := openDB(cliCtx, tenantsCommandName, cmd.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer rdb.Close()
+
+	var updated db.Tenant
+	err = db.RunInTransaction(cliCtx.Context, rdb, func(tx *sqlx.Tx) error {
+		updated, err = db.SetTenantBillingCurrency(cliCtx.Context, tx, cmd.Source, cmd.Currency)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("could not set currency: %w", err)
+	}
+	printTenants(os.Stdout, updated)
+	return nil
+}
+
+func printTenants(out io.Writer, tenants ...db.Tenant) {
+	printTable(out, "ID\tSource\tTarget\tCurrency", tenants, func(t db.Tenant) []interface{} {
+		return []interface{}{t.Id, t.Source, t.Target.String, t.BillingCurrency}
+	})
+}